@@ -103,7 +103,7 @@ func enableDisableFeatureFlag(cmd *cobra.Command, args []string, global bool, or
 	if !foundFlag {
 		cmd.PrintErrf("unsupported feature flag %q\n\nSupported ones are:\n", flag)
 		for _, f := range flags {
-			cmd.PrintErrf("  - %s\n", f)
+			cmd.PrintErrf("  - %s\n", f.Flag)
 		}
 		os.Exit(1)
 	}
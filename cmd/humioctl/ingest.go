@@ -2,106 +2,302 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"os/signal"
+	"path/filepath"
 	"regexp"
-	"syscall"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gofrs/uuid"
-	"github.com/hpcloud/tail"
 	"github.com/humio/cli/internal/api"
 	"github.com/humio/cli/shipper"
 	"github.com/skratchdot/open-golang/open"
 	"github.com/spf13/cobra"
 )
 
-func tailFile(cmd *cobra.Command, filepath string, quiet bool, seekToEnd bool, handler shipper.LineHandler) error {
-	tailConfig := tail.Config{Follow: true}
-	if seekToEnd {
-		tailConfig.Location = &tail.SeekInfo{Offset: 0, Whence: io.SeekEnd}
+func newFileTailer(cmd *cobra.Command, patterns []string, registryPath string, pollInterval time.Duration, sourceField string, quiet bool, seekToEnd bool, newSource func(fields map[string]string) *ingestSource, checkpoint func(done func())) (*fileTailer, error) {
+	tailer := &fileTailer{
+		patterns:     patterns,
+		seekToEnd:    seekToEnd,
+		pollInterval: pollInterval,
+		checkpoint:   checkpoint,
+		sourceFor: func(path string) *ingestSource {
+			var fields map[string]string
+			if sourceField != "" {
+				fields = map[string]string{sourceField: path}
+			}
+			source := newSource(fields)
+			if !quiet {
+				handle := source.lines.Handle
+				source.lines.Handle = func(line string, marks map[string]string) {
+					handle(line, marks)
+					fmt.Fprintln(cmd.OutOrStdout(), line)
+				}
+			}
+			return source
+		},
 	}
 
-	t, err := tail.TailFile(filepath, tailConfig)
+	if registryPath != "" {
+		registry, err := loadTailRegistry(registryPath)
+		if err != nil {
+			return nil, err
+		}
+		tailer.registry = registry
+	}
+
+	return tailer, nil
+}
+
+func listenSyslog(cmd *cobra.Command, addresses []string, quiet bool, newSource func(fields map[string]string) *ingestSource) error {
+	listener := &syslogListener{
+		addresses: addresses,
+		sourceFor: func() *ingestSource {
+			source := newSource(nil)
+			if !quiet {
+				handle := source.lines.Handle
+				source.lines.Handle = func(line string, marks map[string]string) {
+					handle(line, marks)
+					fmt.Fprintln(cmd.OutOrStdout(), line)
+				}
+			}
+			return source
+		},
+	}
+
+	return listener.run(contextCancelledOnInterrupt(context.Background()))
+}
+
+func streamStdin(repo string, quiet bool, lines *shipper.LineBuffer) error {
+	log.Println("Humio Attached to StdIn, Forwarding to '" + repo + "'")
+
+	return streamLines(os.Stdin, quiet, lines)
+}
 
+func streamFile(path string, quiet bool, lines *shipper.LineBuffer) error {
+	// #nosec G304
+	f, err := os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer f.Close()
 
-	for line := range t.Lines {
-		handler.HandleLine(line.Text)
-		if !quiet {
-			fmt.Fprintln(cmd.OutOrStdout(), line.Text)
+	return streamLines(f, quiet, lines)
+}
+
+// streamLines reads lines from reader until it is exhausted. Lines longer
+// than the max size of the buffer are not read into memory in full.
+func streamLines(reader io.Reader, quiet bool, lines *shipper.LineBuffer) error {
+	if !quiet {
+		reader = io.TeeReader(reader, os.Stdout)
+	}
+
+	br := bufio.NewReader(reader)
+	for {
+		chunk, err := br.ReadSlice('\n')
+		switch {
+		case err == nil:
+			lines.Write(bytes.TrimRight(chunk, "\r\n"))
+			lines.EndLine()
+		case errors.Is(err, bufio.ErrBufferFull):
+			lines.Write(chunk)
+		case errors.Is(err, io.EOF):
+			if len(chunk) > 0 {
+				lines.Write(chunk)
+				lines.EndLine()
+			}
+			return nil
+		default:
+			return err
 		}
 	}
+}
 
-	waitForInterrupt()
+// ingestSource is the handler chain of a source, such as a file, starting with the buffer its lines are written to.
+type ingestSource struct {
+	lines *shipper.LineBuffer
+	// finishers are the handlers of the chain buffering the last event until they are finished.
+	finishers []interface{ Finish() }
+	// multiLine is the multi line handler of the chain, if any.
+	multiLine *shipper.MultiLineHandler
+	marker    *markingHandler
+}
 
-	err = t.Stop()
+// setFields replaces the fields added to the lines of the source, such as those of each syslog message.
+func (s *ingestSource) setFields(fields map[string]string) {
+	s.marker.fields = fields
+	s.marker.handler = s.marker.root.WithFields(fields)
+}
 
-	return err
+// buffered returns the number of lines written to the chain that have not been handed on yet.
+func (s *ingestSource) buffered() int {
+	if s.multiLine == nil {
+		return 0
+	}
+	return s.multiLine.Buffered()
 }
 
-func streamStdin(repo string, quiet bool, handler shipper.LineHandler, ingestBufferSize int) error {
-	log.Println("Humio Attached to StdIn, Forwarding to '" + repo + "'")
+// Finish hands over the events buffered by the handler chain.
+func (s *ingestSource) Finish() {
+	for _, f := range s.finishers {
+		f.Finish()
+	}
+}
 
-	var reader io.Reader = os.Stdin
-	if !quiet {
-		reader = io.TeeReader(reader, os.Stdout)
+// markingHandler adds the fields in marks to what is passed through it. It
+// ends the handler chain of a source, so the oversize policy can mark lines.
+type markingHandler struct {
+	root    ingestHandler
+	fields  map[string]string
+	handler shipper.Handler
+	marks   map[string]string
+}
+
+func (h *markingHandler) target() shipper.Handler {
+	if len(h.marks) == 0 {
+		return h.handler
 	}
+	fields := make(map[string]string, len(h.fields)+len(h.marks))
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	for k, v := range h.marks {
+		fields[k] = v
+	}
+	return h.root.WithFields(fields)
+}
 
-	scanner := bufio.NewScanner(reader)
+func (h *markingHandler) HandleLine(line string) {
+	h.target().HandleLine(line)
+}
 
-	// Create the ingest buffer
-	buf := make([]byte, ingestBufferSize)
-	scanner.Buffer(buf, ingestBufferSize)
+func (h *markingHandler) HandleEvent(e shipper.Event) {
+	h.target().HandleEvent(e)
+}
+
+// ingestDestination is a repository to send to, in the cluster of a saved profile.
+type ingestDestination struct {
+	// name is the destination as given by --to, or empty for the repo argument.
+	name    string
+	profile string
+	repo    string
+	client  *api.Client
+}
 
-	for scanner.Scan() {
-		handler.HandleLine(scanner.Text())
+// newIngestDestinations returns the repo argument, or the destinations given by specs, each with a client for the
+// cluster of its profile. The ingest token, if given, replaces the token of the client.
+func newIngestDestinations(repo string, specs []string, ingestToken string) ([]ingestDestination, error) {
+	destinations := []ingestDestination{{repo: repo}}
+	if len(specs) > 0 {
+		destinations = nil
+		for _, spec := range specs {
+			profile, repo := splitProfileRepo(spec)
+			destinations = append(destinations, ingestDestination{name: spec, profile: profile, repo: repo})
+		}
+	}
+
+	var opts []func(config *api.Config)
+	if ingestToken != "" {
+		opts = append(opts, func(config *api.Config) {
+			config.Token = ingestToken
+		})
+	}
+
+	for i, d := range destinations {
+		client, err := newApiClientForProfileE(d.profile, opts...)
+		if err != nil {
+			return nil, err
+		}
+		destinations[i].client = client
+	}
+	return destinations, nil
+}
+
+// ingestURL returns the endpoint to send to. With an ingest token the repository is the one the token belongs to.
+func (d ingestDestination) ingestURL(hec, structured, ingestToken bool) string {
+	switch {
+	case hec:
+		return "api/v1/ingest/hec"
+	case structured && ingestToken:
+		return "api/v1/ingest/humio-structured"
+	case structured:
+		return "api/v1/repositories/" + d.repo + "/ingest"
+	case ingestToken:
+		return "api/v1/ingest/humio-unstructured"
+	default:
+		return "api/v1/repositories/" + d.repo + "/ingest-messages"
 	}
+}
 
-	return scanner.Err()
+// fileName returns the destination name for use in file names.
+func (d ingestDestination) fileName() string {
+	return strings.NewReplacer(":", "-", "/", "-", "\\", "-").Replace(d.name)
 }
 
-func waitForInterrupt() {
-	// Go signal notification works by sending `os.Signal`
-	// values on a channel. We'll create a channel to
-	// receive these notifications (we'll also make one to
-	// notify us when the program can exit).
-	sigs := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
-
-	// `signal.Notify` registers the given channel to
-	// receive notifications of the specified signals.
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-
-	// This goroutine executes a blocking receive for
-	// signals. When it gets one it'll print it out
-	// and then notify the program that it can finish.
-	go func() {
-		sig := <-sigs
-		log.Println()
-		log.Println(sig)
-		close(done)
-	}()
-
-	// The program will wait here until it gets the
-	// expected signal (as indicated by the goroutine
-	// above sending a value on `done`) and then exit.
-	<-done
+// ingestHandler is the start of the pipeline, either a single shipper or a fan-out to several.
+type ingestHandler interface {
+	shipper.Handler
+	WithFields(fields map[string]string) shipper.Handler
+	Checkpoint(done func())
+}
+
+// finishSender logs the final stats of a finished shipper and closes its files.
+func finishSender(sender *shipper.LogShipper, logSummary bool) {
+	if logSummary {
+		logIngestSummary(sender.Stats(), sender.Logger)
+	}
+
+	if sender.Compression != shipper.CompressionNone {
+		stats := sender.Stats()
+		sender.Logger("Sent %d bytes in %d requests, %d bytes before %s compression", stats.BytesSent, stats.Requests, stats.BytesUncompressed, sender.Compression)
+	}
+
+	if sender.Bundle != nil {
+		if closeErr := sender.Bundle.Close(); closeErr != nil {
+			sender.Logger("Error closing bundle: %v", closeErr)
+		} else {
+			stats := sender.Stats()
+			sender.Logger("Wrote %d events in %d batches to %s", stats.EventsSent, stats.BatchesSent, sender.Bundle.Path())
+		}
+	}
+
+	if sender.DeadLetter != nil {
+		if closeErr := sender.DeadLetter.Close(); closeErr != nil {
+			sender.Logger("Error closing dead-letter file: %v", closeErr)
+		}
+	}
+
+	if sender.Spool != nil {
+		if closeErr := sender.Spool.Close(); closeErr != nil {
+			sender.Logger("Error closing spool: %v", closeErr)
+		}
+	}
 }
 
 func newIngestCmd() *cobra.Command {
-	var parserName, filepath, label, ingestToken, multiLineBeginsWith, multiLineContinuesWith, fieldsJson string
-	var openBrowser, noSession, quiet, failOnError, tailSeekToEnd bool
-	var retries, batchSizeLines, batchSizeBytes, batchTimeoutMs, ingestBufferSize int
+	var parserName, label, ingestToken, multiLineBeginsWith, multiLineContinuesWith, fieldsJson, spoolDir, tailRegistryPath, tailSourceField string
+	var inputFile, timestampField, timestampFormat, compression, deadLetterPath, metricsListen string
+	var hecDefaults shipper.HECMetadata
+	var tailPatterns, tagFields, syslogAddresses, destinationSpecs, extractRegexes, redactNames, redactRegexes, includeRegexes, excludeRegexes []string
+	var redactHashKey, sampleField, csvDelimiter, timestampRegex, replayPath, replayOrder, bundlePath, oversizePolicyName string
+	var csvHeader []string
+	var openBrowser, noSession, quiet, failOnError, execCommand, tailSeekToEnd, structured, hec, orderPerSource, multiLineNegate, extractKeyValues, redactHash, csvRows bool
+	var retries, batchSizeLines, batchSizeBytes, batchTimeoutMs, ingestBufferSize, tailPollIntervalMs, concurrency, queueBatches, retryMaxBackoffMs, statsIntervalSec int
+	var multiLineFlushTimeoutMs, multiLineMaxLines, multiLineMaxBytes, sampleRate, fanOutBuffer int
+	var spoolSegmentSizeBytes int64
+	var spoolSyncIntervalMs int
 
 	cmd := cobra.Command{
-		Use:   "ingest [flags] repo",
+		Use:   "ingest [flags] repo [-- command [args...]]",
 		Short: "Send data to Humio.",
 		Long: `Listens to stdin and sends all input to the repository <repo>.
 If the --ingest-token flag is specified, the repo associated with the ingest token will be used.
@@ -116,26 +312,173 @@ You can pipe the output of another process through humio:
   $ tail -f /var/log/syslog | humio ingest --ingest-token=af21... --parser=syslog
 
 Alternatively, you can use the --tail=<file> argument, which
-has the same effect.`,
+has the same effect. --tail can be given several times and accepts
+glob patterns. Files that start matching a pattern are picked up while
+ingesting, rotated files are followed, and each event gets a @source
+field with the path of its file:
+
+  $ humioctl ingest --tail='/var/log/nginx/*.log' --tail=/var/log/syslog
+
+Use --tail-registry=<file> to record how far each file has been read,
+so a restarted ingest continues where it stopped. A line only counts as
+read once it has been sent, written to the spool or dead-letter file, or
+dropped after all attempts, so lines still waiting to be sent when ingest
+stops are read again on restart.
+
+With --replay=<path> the file at the path, or all files in the directory
+and its subdirectories, are read once. Files ending in .gz, .zst or .bz2
+are decompressed, and each file in .tar, .tar.gz, .tgz or .tar.zst
+archives is read. Use --timestamp-regex to send the original timestamp of
+each line, and --quiet to show the progress:
+
+  $ humioctl ingest -q --replay=/backup/logs --timestamp-regex='^(\S+ \S+)' --timestamp-format='2006-01-02 15:04:05' myrepo
+
+With --listen-syslog the CLI receives syslog messages over UDP and TCP
+instead, in the RFC 3164 or RFC 5424 format. Each event gets fields with
+the address of the sender and the priority, facility, severity, hostname
+and app name of the message. Messages longer than --ingest-buffer-size
+are handled by --oversize-policy, whether sent over UDP or TCP:
+
+  $ humioctl ingest --listen-syslog=udp://:5514,tcp://:5514 --parser=syslog myrepo
+
+With --exec the command given after -- is run, and the lines it writes
+to stdout and stderr are sent with a stream field set to 'stdout' or
+'stderr'. When the command exits, an event with its exit code, runtime
+and command line is sent, and ingest exits with the same code. The
+command line is redacted by --redact and --redact-regex like the output:
+
+  $ humioctl ingest --exec myrepo -- ./run-batch-job.sh --verbose
+
+With --structured each line is read as a JSON object and sent to the
+structured ingest endpoint, bypassing the parser. The keys of the object
+become fields of the event:
+
+  $ humioctl ingest --structured --timestamp-field=time --tag=service --file=app.ndjson myrepo
+
+With --csv each line is read as a row of CSV, and sent to the structured
+ingest endpoint with the values keyed by the column names. The first row
+of each file is the header, unless the column names are given with
+--csv-header. Numbers and booleans are sent as such. As --include,
+--exclude and --sample apply to lines rather than rows, they cannot be
+used with --csv:
+
+  $ humioctl ingest --csv --csv-delimiter=tab --timestamp-field=date --timestamp-format=2006-01-02 --file=export.tsv myrepo
+
+With --extract-regex and --extract-kv fields are extracted from each
+line before it is sent, for repositories where the parser cannot be
+changed. The named capture groups of the regex and the key=value pairs of
+the line become fields, and the line is sent to the structured ingest
+endpoint with the fields as attributes, so it is not parsed by Humio and
+--parser cannot be given:
+
+  $ humioctl ingest --extract-kv --extract-regex='^(?P<level>[A-Z]+) ' myrepo
+
+With --multiline-begins-with or --multiline-continues-with lines are
+joined into multi line events, such as stack traces. An event is sent
+when the next one starts, when no line has been added to it for
+--multiline-flush-timeout (5 seconds), or when it reaches
+--multiline-max-lines (500) lines or --multiline-max-bytes bytes, so a
+longer trace is split into several events. The lines of an event are
+joined by newlines, without a trailing newline:
+
+  $ humioctl ingest --tail=/var/log/app.log --multiline-begins-with='^\d{4}-\d{2}-\d{2}' myrepo
+
+With --include and --exclude only the lines matching an --include regex
+and not matching any --exclude regex are sent. With --sample=<n> one in n
+lines is sent, or with --sample-field=<field> the lines whose value of
+the field hashes to one in n values, so related lines are kept together.
+Filtering is done on whole multi line events. The number of dropped lines
+is logged on exit:
+
+  $ humioctl ingest --tail=/var/log/app.log --exclude=' DEBUG ' --sample=10 --sample-field=trace_id myrepo
+
+With --redact and --redact-regex sensitive data is masked before it
+leaves the host. With --redact-hash it is replaced by a hash instead, so
+equal values can still be correlated:
+
+  $ humioctl ingest --redact=email,credit-card --redact-regex='password=(\S+)' myrepo
+
+Lines longer than --ingest-buffer-size bytes are truncated and get a
+@truncated=true field. With --oversize-policy=split they are sent as
+several events instead, sharing a @chunk_id field and numbered by a
+@chunk field, and with --oversize-policy=drop they are not sent. Long
+lines are never read into memory in full:
+
+  $ humioctl ingest --ingest-buffer-size=65536 --oversize-policy=split --file=dump.log myrepo
+
+With --hec events are sent to the HEC endpoint in the Splunk HTTP Event
+Collector format, which requires an ingest token. Lines that already are
+HEC event objects, such as captured HEC payloads, are forwarded as they
+are. Other lines are sent as the event of a new HEC object.
+
+With --spool-dir=<dir> every event is written to disk before it is sent,
+and events that could not be sent are kept there. They are sent again
+the next time ingest is started with the same spool directory, so no
+data is lost if Humio is unreachable or the process is restarted.
+Events may be sent more than once. Events are synced to disk within
+--spool-sync-interval milliseconds of being written.
+
+With --output-bundle=<file> the requests are written to the file instead
+of being sent, for hosts without access to Humio. Send them later from
+another host using:
+
+  $ humioctl ingest upload-bundle <file> <repo>
+
+With --to=<profile>:<repo>, given several times instead of the repo
+argument, the same input is sent to each repository, using the address
+and token of the saved profile. Each destination is sent to separately,
+so a slow destination only holds up the others once --to-buffer events
+are waiting for it. Spools and dead-letter files get one directory or
+file per destination. As an ingest token belongs to one repository,
+--ingest-token cannot be used with more than one --to:
+
+  $ humioctl ingest --to=old:logs --to=new:logs --tail=/var/log/syslog
+
+With --dead-letter=<file> batches that could not be sent are appended to
+the file, along with the error. Send them again later using:
+
+  $ humioctl ingest replay <file>`,
 		ValidArgs: []string{"repo"},
-		Args:      cobra.RangeArgs(0, 1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				args = args[:dash]
+			}
+			return cobra.RangeArgs(0, 1)(cmd, args)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var repo string
 
+			var command []string
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				command = args[dash:]
+				args = args[:dash]
+			}
+			if execCommand && len(command) == 0 {
+				log.Fatal("Must specify the command to run after -- when using --exec")
+			}
+			if !execCommand && len(command) > 0 {
+				log.Fatal("Must specify --exec to run a command")
+			}
+
 			if l := len(args); l == 1 {
 				repo = args[0]
-			} else {
+			} else if len(destinationSpecs) == 0 {
 				log.Fatal("Must specify repo to ingest data")
 			}
+			if repo != "" && len(destinationSpecs) > 0 {
+				log.Fatal("Cannot specify both a repo and --to")
+			}
 
-			var opts []func(config *api.Config)
-			if ingestToken != "" {
-				opts = append(opts, func(config *api.Config) {
-					config.Token = ingestToken
-				})
+			if ingestToken != "" && len(destinationSpecs) > 1 {
+				log.Fatal("Cannot specify --ingest-token with more than one --to, as the token only belongs to one repository")
 			}
 
-			client := NewApiClient(cmd, opts...)
+			destinations, err := newIngestDestinations(repo, destinationSpecs, ingestToken)
+			exitOnError(cmd, err, "Error creating HTTP client")
+			if len(destinationSpecs) > 0 {
+				repo = strings.Join(destinationSpecs, "', '")
+			}
+			client := destinations[0].client
 
 			var key string
 			fields := map[string]string{}
@@ -167,7 +510,7 @@ has the same effect.`,
 
 			// Open the browser
 			if openBrowser {
-				browserURL, err := client.Address().Parse(fmt.Sprintf("%s/search?live=true&start=1d&query=%s", repo, key))
+				browserURL, err := client.Address().Parse(fmt.Sprintf("%s/search?live=true&start=1d&query=%s", destinations[0].repo, key))
 				if err != nil {
 					cmd.PrintErrf("Could not parse url: %v\n", err)
 				}
@@ -177,84 +520,531 @@ has the same effect.`,
 				}
 			}
 
-			var url string
-			if ingestToken != "" {
-				url = "api/v1/ingest/humio-unstructured"
-			} else {
-				url = "api/v1/repositories/" + repo + "/ingest-messages"
+			extracting := len(extractRegexes) > 0 || extractKeyValues || timestampRegex != ""
+			if extracting && (structured || hec) {
+				log.Fatal("Cannot extract fields or use --timestamp-regex with --structured or --hec")
+			}
+			// Extracted fields are sent to the structured ingest endpoint, which does not run a parser.
+			if extracting && cmd.Flags().Changed("parser") {
+				log.Fatal("Cannot specify --parser with --extract-regex, --extract-kv or --timestamp-regex, the lines are not parsed by Humio")
+			}
+			var timestampExtractor *regexp.Regexp
+			if timestampRegex != "" {
+				var err error
+				timestampExtractor, err = regexp.Compile(timestampRegex)
+				if err != nil {
+					log.Fatalf("Error parsing --timestamp-regex value: %v", err)
+				}
+			}
+			var extractors []*regexp.Regexp
+			for _, r := range extractRegexes {
+				extractor, err := regexp.Compile(r)
+				if err != nil {
+					log.Fatalf("Error parsing --extract-regex value: %v", err)
+				}
+				extractors = append(extractors, extractor)
 			}
 
-			sender := shipper.LogShipper{
-				APIClient:           client,
-				URL:                 url,
-				Fields:              fields,
-				ParserName:          parserName,
-				MaxAttemptsPerBatch: retries + 1,
-				BatchSizeLines:      batchSizeLines,
-				BatchSizeBytes:      batchSizeBytes,
-				BatchTimeout:        time.Duration(batchTimeoutMs) * time.Millisecond,
-				Logger:              log.New(cmd.ErrOrStderr(), "", log.LstdFlags).Printf,
+			var redactionPatterns []shipper.RedactionPattern
+			for _, name := range redactNames {
+				if name == "all" {
+					for _, name := range shipper.BuiltinRedactionPatternNames() {
+						redactionPatterns = append(redactionPatterns, shipper.BuiltinRedactionPatterns[name])
+					}
+					continue
+				}
+				pattern, ok := shipper.BuiltinRedactionPatterns[name]
+				if !ok {
+					log.Fatalf("Unknown --redact value %q, expected one of: all, %s", name, strings.Join(shipper.BuiltinRedactionPatternNames(), ", "))
+				}
+				redactionPatterns = append(redactionPatterns, pattern)
+			}
+			for _, r := range redactRegexes {
+				regex, err := regexp.Compile(r)
+				if err != nil {
+					log.Fatalf("Error parsing --redact-regex value: %v", err)
+				}
+				redactionPatterns = append(redactionPatterns, shipper.RedactionPattern{Regex: regex})
+			}
+
+			// The filters are shared by all sources, so the dropped lines are counted in one place.
+			var filters []shipper.LineFilter
+			var regexFilter *shipper.RegexFilter
+			if len(includeRegexes) > 0 || len(excludeRegexes) > 0 {
+				regexFilter = &shipper.RegexFilter{}
+				for _, r := range includeRegexes {
+					regex, err := regexp.Compile(r)
+					if err != nil {
+						log.Fatalf("Error parsing --include value: %v", err)
+					}
+					regexFilter.Include = append(regexFilter.Include, regex)
+				}
+				for _, r := range excludeRegexes {
+					regex, err := regexp.Compile(r)
+					if err != nil {
+						log.Fatalf("Error parsing --exclude value: %v", err)
+					}
+					regexFilter.Exclude = append(regexFilter.Exclude, regex)
+				}
+				filters = append(filters, regexFilter)
+			}
+			var sampler *shipper.Sampler
+			if sampleRate > 1 {
+				sampler = &shipper.Sampler{Rate: sampleRate, KeyField: sampleField}
+				filters = append(filters, sampler)
+			}
+
+			var csvComma rune
+			if csvRows {
+				if structured || hec || extracting {
+					log.Fatal("Cannot specify --csv with --structured, --hec or field extraction")
+				}
+				if multiLineBeginsWith != "" || multiLineContinuesWith != "" {
+					log.Fatal("Cannot use multi line mode with --csv")
+				}
+				if len(filters) > 0 {
+					log.Fatal("Cannot use --include, --exclude or --sample with --csv, as they would drop header lines and split quoted values")
+				}
+				switch csvDelimiter {
+				case "tab", `\t`, "\t":
+					csvComma = '\t'
+				default:
+					if utf8.RuneCountInString(csvDelimiter) != 1 {
+						log.Fatalf("Invalid --csv-delimiter value %q, expected a single character or 'tab'", csvDelimiter)
+					}
+					csvComma, _ = utf8.DecodeRuneInString(csvDelimiter)
+				}
+			}
+
+			if len(syslogAddresses) > 0 && structured {
+				log.Fatal("Cannot specify both --listen-syslog and --structured")
+			}
+			if len(syslogAddresses) > 0 && (multiLineBeginsWith != "" || multiLineContinuesWith != "") {
+				log.Fatal("Cannot use multi line mode with --listen-syslog")
+			}
+			if structured && hec {
+				log.Fatal("Cannot specify both --structured and --hec")
+			}
+			if hec && ingestToken == "" {
+				log.Fatal("Must specify --ingest-token when using --hec")
+			}
+
+			if bundlePath != "" && len(destinations) > 1 {
+				log.Fatal("Cannot specify both --output-bundle and more than one --to")
+			}
+
+			logger := log.New(cmd.ErrOrStderr(), "", log.LstdFlags).Printf
+
+			compressionValue, err := shipper.ParseCompression(compression)
+			if err != nil {
+				log.Fatalf("Error parsing --compress value: %v", err)
+			}
+
+			oversizePolicy, err := shipper.ParseOversizePolicy(oversizePolicyName)
+			if err != nil {
+				log.Fatalf("Error parsing --oversize-policy value: %v", err)
+			}
+			if ingestBufferSize < 1 {
+				log.Fatalf("Invalid --ingest-buffer-size value %d, expected at least 1 byte", ingestBufferSize)
+			}
+
+			// Each destination gets its own shipper, with its own retries, spool, dead-letter file and metrics.
+			newSender := func(d ingestDestination) *shipper.LogShipper {
+				url := d.ingestURL(hec, structured || extracting || csvRows, ingestToken != "")
+
+				senderLogger := logger
+				if d.name != "" {
+					senderLogger = log.New(cmd.ErrOrStderr(), "["+d.name+"] ", log.LstdFlags).Printf
+				}
+
+				sender := &shipper.LogShipper{
+					APIClient:           d.client,
+					URL:                 url,
+					Fields:              fields,
+					ParserName:          parserName,
+					MaxAttemptsPerBatch: retries + 1,
+					Compression:         compressionValue,
+					BatchSizeLines:      batchSizeLines,
+					BatchSizeBytes:      batchSizeBytes,
+					BatchTimeout:        time.Duration(batchTimeoutMs) * time.Millisecond,
+					Logger:              senderLogger,
+					Concurrency:         concurrency,
+					QueueSize:           queueBatches,
+					OrderPerSource:      orderPerSource,
+				}
+
+				if failOnError {
+					sender.ErrorBehaviour = shipper.ErrorBehaviourPanic
+				}
+
+				retryPolicy := shipper.DefaultRetryPolicy()
+				retryPolicy.MaxDelay = time.Duration(retryMaxBackoffMs) * time.Millisecond
+				sender.RetryPolicy = retryPolicy
+
+				switch {
+				case structured || extracting || csvRows:
+					sender.Format = shipper.IngestFormatStructured
+				case hec:
+					sender.Format = shipper.IngestFormatHEC
+					sender.HECDefaults = hecDefaults
+				}
+
+				if spoolDir != "" {
+					dir := spoolDir
+					if len(destinations) > 1 {
+						dir = filepath.Join(spoolDir, d.fileName())
+					}
+					spool, err := shipper.OpenSpool(dir, spoolSegmentSizeBytes)
+					if err != nil {
+						log.Fatalf("Error opening spool: %v", err)
+					}
+					spool.SyncInterval = time.Duration(spoolSyncIntervalMs) * time.Millisecond
+					sender.Spool = spool
+				}
+
+				if deadLetterPath != "" {
+					path := deadLetterPath
+					if len(destinations) > 1 {
+						path = deadLetterPath + "." + d.fileName()
+					}
+					deadLetter, err := shipper.OpenDeadLetterFile(path)
+					if err != nil {
+						log.Fatalf("Error opening dead-letter file: %v", err)
+					}
+					sender.DeadLetter = deadLetter
+				}
+
+				if bundlePath != "" {
+					bundle, err := shipper.CreateBundle(bundlePath)
+					if err != nil {
+						log.Fatalf("Error creating bundle: %v", err)
+					}
+					sender.Bundle = bundle
+				}
+
+				return sender
+			}
+
+			var senders []*shipper.LogShipper
+			for _, d := range destinations {
+				sender := newSender(d)
+				sender.Start()
+				senders = append(senders, sender)
 			}
 
-			if failOnError {
-				sender.ErrorBehaviour = shipper.ErrorBehaviourPanic
+			var root ingestHandler = senders[0]
+			var fanOut *shipper.FanOut
+			if len(senders) > 1 {
+				fanOut = shipper.NewFanOut(fanOutBuffer, senders...)
+				root = fanOut
 			}
 
-			sender.Start()
+			// The filtered lines never reach the senders, so they are counted as filtered for every destination.
+			stats := func() map[string]shipper.Stats {
+				var filtered uint64
+				for _, f := range filters {
+					filtered += f.Dropped()
+				}
+				stats := map[string]shipper.Stats{}
+				for i, sender := range senders {
+					s := sender.Stats()
+					s.LinesFiltered = filtered
+					stats[destinations[i].name] = s
+				}
+				return stats
+			}
 
-			var lineHandler shipper.LineHandler = &sender
+			if metricsListen != "" {
+				server, err := serveIngestMetrics(metricsListen, stats)
+				if err != nil {
+					log.Fatalf("Error listening for metrics: %v", err)
+				}
+				defer server.Close()
+			}
+
+			stopStats := make(chan struct{})
+			if statsIntervalSec > 0 {
+				for _, sender := range senders {
+					go logIngestStats(time.Duration(statsIntervalSec)*time.Second, sender.Stats, sender.Logger, stopStats)
+				}
+			}
+
+			var multiLineRegex *regexp.Regexp
+			var multiLineMode shipper.MultiLineHandlerMode
 
 			switch {
 			case multiLineBeginsWith != "" && multiLineContinuesWith != "":
 				log.Fatalf("Cannot specify both --multiline-begins-with and --multiline-continues-with")
 			case multiLineBeginsWith != "":
-				lineHandler = &shipper.MultiLineHandler{
-					LineHandler: lineHandler,
-					Regex:       regexp.MustCompile(multiLineBeginsWith),
-					Mode:        shipper.MultiLineHandlerModeBeginsWith,
-				}
+				multiLineRegex = regexp.MustCompile(multiLineBeginsWith)
+				multiLineMode = shipper.MultiLineHandlerModeBeginsWith
 			case multiLineContinuesWith != "":
-				lineHandler = &shipper.MultiLineHandler{
-					LineHandler: lineHandler,
-					Regex:       regexp.MustCompile(multiLineContinuesWith),
-					Mode:        shipper.MultiLineHandlerModeContinuesWith,
+				multiLineRegex = regexp.MustCompile(multiLineContinuesWith)
+				multiLineMode = shipper.MultiLineHandlerModeContinuesWith
+			}
+
+			// Multi line and CSV handlers buffer the last event of each source until they are finished.
+			var finishersMu sync.Mutex
+			var finishers []interface{ Finish() }
+			addFinisher := func(f interface{ Finish() }) {
+				finishersMu.Lock()
+				finishers = append(finishers, f)
+				finishersMu.Unlock()
+			}
+
+			withFields := func(fields map[string]string) shipper.Handler {
+				if len(fields) > 0 {
+					return root.WithFields(fields)
 				}
+				return root
 			}
 
-			var err error
-			if filepath != "" {
-				err = tailFile(cmd, filepath, quiet, tailSeekToEnd, lineHandler)
-			} else {
-				err = streamStdin(repo, quiet, lineHandler, ingestBufferSize)
+			// Each source gets its own handler chain so multi line events from different files are not mixed.
+			newHandlerChain := func(handler shipper.Handler, source *ingestSource) shipper.LineHandler {
+				var lineHandler shipper.LineHandler = handler
+				if structured {
+					lineHandler = &shipper.JSONLineHandler{
+						EventHandler:    handler,
+						TimestampField:  timestampField,
+						TimestampFormat: timestampFormat,
+						TagFields:       tagFields,
+						Logger:          logger,
+					}
+				}
+				if csvRows {
+					csvHandler := &shipper.CSVLineHandler{
+						EventHandler:    handler,
+						Delimiter:       csvComma,
+						Header:          csvHeader,
+						TimestampColumn: timestampField,
+						TimestampFormat: timestampFormat,
+						TagColumns:      tagFields,
+						Logger:          logger,
+					}
+					source.finishers = append(source.finishers, csvHandler)
+					lineHandler = csvHandler
+				}
+				if extracting {
+					lineHandler = &shipper.FieldExtractor{
+						EventHandler:    handler,
+						Regexes:         extractors,
+						KeyValues:       extractKeyValues,
+						TimestampField:  timestampField,
+						TimestampRegex:  timestampExtractor,
+						TimestampFormat: timestampFormat,
+					}
+				}
+				if len(redactionPatterns) > 0 {
+					lineHandler = &shipper.RedactionHandler{
+						LineHandler: lineHandler,
+						Patterns:    redactionPatterns,
+						Hash:        redactHash,
+						HashKey:     []byte(redactHashKey),
+					}
+				}
+				for i := len(filters) - 1; i >= 0; i-- {
+					lineHandler = &shipper.FilterHandler{LineHandler: lineHandler, Filter: filters[i]}
+				}
+				if multiLineRegex != nil {
+					multiLineHandler := &shipper.MultiLineHandler{
+						LineHandler:  lineHandler,
+						Regex:        multiLineRegex,
+						Mode:         multiLineMode,
+						Negate:       multiLineNegate,
+						FlushTimeout: time.Duration(multiLineFlushTimeoutMs) * time.Millisecond,
+						MaxLines:     multiLineMaxLines,
+						MaxBytes:     multiLineMaxBytes,
+					}
+					source.finishers = append(source.finishers, multiLineHandler)
+					source.multiLine = multiLineHandler
+					lineHandler = multiLineHandler
+				}
+				return lineHandler
+			}
+
+			// Lines longer than --ingest-buffer-size are handled by the oversize policy. In multi line mode the
+			// lines of an event are sent later, so they are truncated, split or dropped but not marked.
+			oversizeStats := &shipper.OversizeStats{}
+			newSource := func(fields map[string]string) *ingestSource {
+				marker := &markingHandler{root: root, fields: fields, handler: withFields(fields)}
+				source := &ingestSource{marker: marker}
+				chain := newHandlerChain(marker, source)
+				source.lines = &shipper.LineBuffer{
+					MaxSize: ingestBufferSize,
+					Policy:  oversizePolicy,
+					Stats:   oversizeStats,
+					Handle: func(line string, marks map[string]string) {
+						if multiLineRegex == nil {
+							marker.marks = marks
+						}
+						chain.HandleLine(line)
+						marker.marks = nil
+					},
+				}
+				return source
+			}
+			newLineBuffer := func(fields map[string]string) *shipper.LineBuffer {
+				source := newSource(fields)
+				addFinisher(source)
+				return source.lines
+			}
+
+			var commandResult execResult
+			var tailer *fileTailer
+
+			switch {
+			case execCommand:
+				commandResult, err = runCommand(command, quiet, newLineBuffer)
+			case len(syslogAddresses) > 0:
+				err = listenSyslog(cmd, syslogAddresses, quiet, newSource)
+			case replayPath != "":
+				err = replayArchives(replayPath, replayOrder, quiet, tailSourceField, newLineBuffer)
+			case len(tailPatterns) > 0:
+				tailer, err = newFileTailer(cmd, tailPatterns, tailRegistryPath, time.Duration(tailPollIntervalMs)*time.Millisecond, tailSourceField, quiet, tailSeekToEnd, newSource, root.Checkpoint)
+				if err == nil {
+					err = tailer.run(contextCancelledOnInterrupt(context.Background()))
+				}
+			case inputFile != "":
+				err = streamFile(inputFile, quiet, newLineBuffer(nil))
+			default:
+				err = streamStdin(repo, quiet, newLineBuffer(nil))
 			}
 
-			sender.Finish()
+			finishersMu.Lock()
+			for _, f := range finishers {
+				f.Finish()
+			}
+			finishersMu.Unlock()
+
+			// The summary is sent last, bypassing the handler chain as it is not output of the command. The command
+			// line is still redacted, as it may hold secrets given as arguments.
+			if execCommand && err == nil {
+				message, fields := commandResult.summary()
+				if len(redactionPatterns) > 0 {
+					redaction := &shipper.RedactionHandler{Patterns: redactionPatterns, Hash: redactHash, HashKey: []byte(redactHashKey)}
+					message = redaction.Redact(message)
+					fields["command"] = redaction.Redact(fields["command"])
+				}
+				root.WithFields(fields).HandleLine(message)
+			}
+
+			if fanOut != nil {
+				fanOut.Finish()
+			}
+			for _, sender := range senders {
+				sender.Finish()
+			}
+			close(stopStats)
+
+			// The tail registry is saved last, when the shippers have settled the lines read.
+			if tailer != nil {
+				if saveErr := tailer.saveRegistry(); saveErr != nil {
+					logger("Error saving tail registry: %v", saveErr)
+				}
+			}
+
+			if regexFilter != nil {
+				logger("Dropped %d lines not matching --include or matching --exclude", regexFilter.Dropped())
+			}
+			if sampler != nil {
+				logger("Dropped %d lines by sampling", sampler.Dropped())
+			}
+			if n := oversizeStats.Truncated.Load(); n > 0 {
+				logger("Truncated %d lines longer than --ingest-buffer-size", n)
+			}
+			if n := oversizeStats.Split.Load(); n > 0 {
+				logger("Split %d lines longer than --ingest-buffer-size", n)
+			}
+			if n := oversizeStats.Dropped.Load(); n > 0 {
+				logger("Dropped %d lines longer than --ingest-buffer-size", n)
+			}
+
+			for _, sender := range senders {
+				finishSender(sender, statsIntervalSec > 0)
+			}
 
 			if err != nil {
 				log.Fatal(err)
 			}
 
+			if commandResult.exitCode != 0 {
+				os.Exit(commandResult.exitCode)
+			}
+
 			return nil
 		},
 	}
 
 	cmd.Flags().StringVarP(&parserName, "parser", "p", "default", "Use a specific parser for ingestion.")
-	cmd.Flags().StringVarP(&filepath, "tail", "f", "", "A file to tail instead of listening to stdin.")
-	cmd.Flags().BoolVarP(&tailSeekToEnd, "tail-end", "E", false, "When used with --tail, start from the end of the file and follow it. Equivalent to 'tail -f -n0 <file>'")
+	cmd.Flags().StringArrayVarP(&tailPatterns, "tail", "f", nil, "A file or glob pattern to tail instead of listening to stdin. Can be given multiple times.")
+	cmd.Flags().BoolVarP(&tailSeekToEnd, "tail-end", "E", false, "When used with --tail, start from the end of the files found at startup and follow them. Equivalent to 'tail -f -n0 <file>'")
+	cmd.Flags().StringVar(&tailRegistryPath, "tail-registry", "", "When used with --tail, record the read offset of each file in this file and resume from it on restart.")
+	cmd.Flags().StringVar(&replayPath, "replay", "", "Read the file, or all files in the directory, at this path instead of listening to stdin. Compressed files and tar archives are read.")
+	cmd.Flags().StringVar(&replayOrder, "replay-order", replayOrderName, "When used with --replay, the order to read the files in: 'name' or 'mtime'.")
+	cmd.Flags().StringVar(&tailSourceField, "tail-source-field", "@source", "When used with --tail or --replay, the field holding the path of the file each event was read from. Set to empty to disable.")
+	cmd.Flags().IntVar(&tailPollIntervalMs, "tail-poll-interval", 250, "When used with --tail, how often in milliseconds to check the files for new data.")
+	cmd.Flags().BoolVar(&execCommand, "exec", false, "Run the command given after -- and send its output instead of listening to stdin. Exits with the exit code of the command.")
+	cmd.Flags().StringSliceVar(&syslogAddresses, "listen-syslog", nil, "Receive syslog messages at these comma separated addresses, e.g. 'udp://:5514,tcp://:5514', instead of listening to stdin.")
+	cmd.Flags().StringArrayVar(&destinationSpecs, "to", nil, "Send to this repo, as '<profile>:<repo>' or '<repo>' for the current profile, instead of the repo argument. Can be given multiple times.")
+	cmd.Flags().IntVar(&fanOutBuffer, "to-buffer", 10000, "When used with more than one --to, the max number of events waiting for a destination before reading input is paused.")
 	cmd.Flags().StringVarP(&ingestToken, "ingest-token", "i", "", "Use the specified ingest token instead of the API token.")
 	cmd.Flags().BoolVarP(&openBrowser, "open", "o", false, "Open the browser with live tail of the stream.")
 	cmd.Flags().StringVarP(&label, "label", "l", "", "Adds a @label=<label> field to each event. This can help you find specific data sent by the CLI when searching in the UI.")
 	cmd.Flags().BoolVarP(&noSession, "no-session", "n", false, "No @session field will be added to each event. @session assigns a new UUID to each executing of the Humio CLI.")
 	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Don't print ingested data to stdout.")
 	cmd.Flags().BoolVarP(&failOnError, "fail", "e", false, "Stop processing more input when sending events has failed (after the allowed number of retries).")
-	cmd.Flags().IntVarP(&retries, "retries", "r", 2, "Number of retries when Humio sending events.")
+	cmd.Flags().IntVarP(&retries, "retries", "r", 2, "Number of retries when Humio sending events. Requests rejected because of e.g. an invalid token or parser are not retried.")
+	cmd.Flags().IntVar(&retryMaxBackoffMs, "retry-max-backoff", 30000, "Max duration in milliseconds to wait between retries, unless Humio asks for a longer wait.")
 	cmd.Flags().IntVarP(&batchSizeLines, "batch-lines", "L", 500, "Max number of events to send in one batch.")
 	cmd.Flags().IntVarP(&batchSizeBytes, "batch-bytes", "B", 1024*1024, "Max number of bytes to send in one batch.")
 	cmd.Flags().IntVarP(&batchTimeoutMs, "batch-timeout", "T", 100, "Max duration in milliseconds to wait before sending an incomplete batch.")
-	cmd.Flags().IntVarP(&ingestBufferSize, "ingest-buffer-size", "", 1*1024*1024, "Sets the maximum event size.")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 1, "Max number of batches to send at the same time.")
+	cmd.Flags().IntVar(&queueBatches, "queue-batches", 0, "Max number of complete batches waiting to be sent before reading input is paused. Defaults to the value of --concurrency.")
+	cmd.Flags().BoolVar(&orderPerSource, "order-per-source", false, "When used with --concurrency, send the events of each tailed file in order.")
+	cmd.Flags().IntVarP(&ingestBufferSize, "ingest-buffer-size", "", 1*1024*1024, "Sets the maximum line size. Longer lines are handled as set by --oversize-policy.")
+	cmd.Flags().StringVar(&oversizePolicyName, "oversize-policy", "truncate", "What to do with lines longer than --ingest-buffer-size: 'truncate' and add @truncated=true, 'split' into events with @chunk_id and @chunk fields, or 'drop'.")
 	cmd.Flags().StringVarP(&multiLineBeginsWith, "multiline-begins-with", "", "", "Operate in multi line mode. Each multi line event starts with the specified regexp pattern.")
 	cmd.Flags().StringVarP(&multiLineContinuesWith, "multiline-continues-with", "", "", "Operate in multi line mode. Each multi line event is continued with the specified regexp pattern.")
+	cmd.Flags().BoolVar(&multiLineNegate, "multiline-negate", false, "Invert the match of --multiline-begins-with or --multiline-continues-with.")
+	cmd.Flags().IntVar(&multiLineFlushTimeoutMs, "multiline-flush-timeout", 5000, "Send a multi line event when no line has been added to it for this many milliseconds. Set to 0 to wait for the next event.")
+	cmd.Flags().IntVar(&multiLineMaxLines, "multiline-max-lines", 500, "Max number of lines in a multi line event. Longer events are split. Set to 0 for no limit.")
+	cmd.Flags().IntVar(&multiLineMaxBytes, "multiline-max-bytes", 0, "Max number of bytes in a multi line event. Longer events are split. Set to 0 for no limit.")
 	cmd.Flags().StringVarP(&fieldsJson, "fields-json", "J", "", "Add the supplied json object to each object as structured fields.")
+	cmd.Flags().StringVar(&inputFile, "file", "", "Read the contents of this file instead of listening to stdin.")
+	cmd.Flags().BoolVar(&structured, "structured", false, "Read each line as a JSON object and send it to the structured ingest endpoint without parsing it.")
+	cmd.Flags().StringArrayVar(&extractRegexes, "extract-regex", nil, "Extract the named capture groups of this regex as fields of each line. Can be given multiple times.")
+	cmd.Flags().BoolVar(&extractKeyValues, "extract-kv", false, "Extract key=value pairs, as in logfmt, as fields of each line.")
+	cmd.Flags().StringArrayVar(&includeRegexes, "include", nil, "Only send lines matching this regex. Can be given multiple times to send lines matching any of them.")
+	cmd.Flags().StringArrayVar(&excludeRegexes, "exclude", nil, "Do not send lines matching this regex. Can be given multiple times.")
+	cmd.Flags().IntVar(&sampleRate, "sample", 0, "Only send 1 in this many lines.")
+	cmd.Flags().StringVar(&sampleField, "sample-field", "", "When used with --sample, choose the lines to send by a hash of the value of this key=value or JSON field.")
+	cmd.Flags().StringSliceVar(&redactNames, "redact", nil, "Mask built-in patterns of sensitive data in each line: 'email', 'credit-card', 'jwt', 'aws-access-key', 'aws-secret-key' or 'all'.")
+	cmd.Flags().StringArrayVar(&redactRegexes, "redact-regex", nil, "Mask matches of this regex in each line, or only its capture groups if it has any. Can be given multiple times.")
+	cmd.Flags().BoolVar(&redactHash, "redact-hash", false, "Replace redacted values with a hash of them instead of a mask.")
+	cmd.Flags().StringVar(&redactHashKey, "redact-hash-key", "", "When used with --redact-hash, the secret key of the hash.")
+	cmd.Flags().BoolVar(&csvRows, "csv", false, "Read each line as a row of CSV and send it to the structured ingest endpoint with the values keyed by the column names.")
+	cmd.Flags().StringVar(&csvDelimiter, "csv-delimiter", ",", "When used with --csv, the character separating values, or 'tab'.")
+	cmd.Flags().StringSliceVar(&csvHeader, "csv-header", nil, "When used with --csv, the comma separated column names. The first row is used as the header if not set.")
+	cmd.Flags().StringVar(&timestampField, "timestamp-field", "", "When used with --structured, --csv or field extraction, the key or column holding the timestamp of each event. The time of ingestion is used if not set.")
+	cmd.Flags().StringVar(&timestampRegex, "timestamp-regex", "", "Find the timestamp of each line with this regex and send it to the structured ingest endpoint. The first capture group is used, or the whole match if there are none.")
+	cmd.Flags().StringVar(&timestampFormat, "timestamp-format", shipper.TimestampFormatRFC3339, "The format of timestamps: 'rfc3339', 'unix', 'unixmillis' or a Go time layout such as '2006-01-02 15:04:05'.")
+	cmd.Flags().StringArrayVar(&tagFields, "tag", nil, "When used with --structured or --csv, a key or column to send as a tag instead of an attribute. Can be given multiple times.")
+	cmd.Flags().BoolVar(&hec, "hec", false, "Send events in the Splunk HEC format to the HEC endpoint. Requires --ingest-token.")
+	cmd.Flags().StringVar(&hecDefaults.Host, "hec-host", "", "When used with --hec, the host of events that do not specify one.")
+	cmd.Flags().StringVar(&hecDefaults.Source, "hec-source", "", "When used with --hec, the source of events that do not specify one.")
+	cmd.Flags().StringVar(&hecDefaults.SourceType, "hec-sourcetype", "", "When used with --hec, the sourcetype of events that do not specify one.")
+	cmd.Flags().StringVar(&hecDefaults.Index, "hec-index", "", "When used with --hec, the index of events that do not specify one.")
+	cmd.Flags().StringVar(&bundlePath, "output-bundle", "", "Write the requests to this file instead of sending them, gzip compressed if it ends in .gz. Use 'ingest upload-bundle' to send them.")
+	cmd.Flags().StringVar(&deadLetterPath, "dead-letter", "", "Append batches that could not be sent to this file. Use 'ingest replay' to send them again.")
+	cmd.Flags().StringVar(&metricsListen, "metrics-listen", "", "Serve metrics in the Prometheus text format on /metrics at this address, e.g. ':9102'.")
+	cmd.Flags().IntVar(&statsIntervalSec, "stats-interval", 0, "Log a summary of the ingest metrics to stderr every this many seconds.")
+	cmd.Flags().StringVar(&compression, "compress", "none", "Compress request bodies with 'gzip' or 'zstd'.")
+	cmd.Flags().StringVar(&spoolDir, "spool-dir", "", "Write events to this directory before sending them and keep events that could not be sent for the next run.")
+	cmd.Flags().Int64Var(&spoolSegmentSizeBytes, "spool-segment-bytes", shipper.DefaultSpoolSegmentSizeBytes, "Max size of each spool segment file in bytes.")
+	cmd.Flags().IntVar(&spoolSyncIntervalMs, "spool-sync-interval", int(shipper.DefaultSpoolSyncInterval/time.Millisecond), "Max duration in milliseconds before events written to the spool are synced to disk. Set to 0 to sync every event, which is slower.")
+
+	cmd.AddCommand(newIngestReplayCmd())
+	cmd.AddCommand(newIngestUploadBundleCmd())
 
 	return &cmd
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/humio/cli/prompt"
+	"github.com/humio/cli/shipper"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Orders in which --replay reads the files of a directory.
+const (
+	replayOrderName  = "name"
+	replayOrderMtime = "mtime"
+)
+
+type replayFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// findReplayFiles returns the files at root, recursing into directories, in the given order.
+func findReplayFiles(root, order string) ([]replayFile, error) {
+	var files []replayFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, replayFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch order {
+	case replayOrderName:
+		sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	case replayOrderMtime:
+		sort.SliceStable(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	default:
+		return nil, fmt.Errorf("unknown order %q, expected %q or %q", order, replayOrderName, replayOrderMtime)
+	}
+
+	return files, nil
+}
+
+// replayArchives reads the lines of the files at root, decompressing .gz, .zst and .bz2
+// files and reading each file in .tar, .tar.gz, .tgz and .tar.zst archives.
+func replayArchives(root, order string, quiet bool, sourceField string, newLineBuffer func(fields map[string]string) *shipper.LineBuffer) error {
+	files, err := findReplayFiles(root, order)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+
+	// The progress bar is only shown when the lines are not printed, as they would be mixed.
+	var processed atomic.Int64
+	var bar *prompt.ProgressBar
+	if quiet {
+		bar = prompt.NewProgressBar(
+			prompt.ProgressOptionDescription("Replaying..."),
+			prompt.ProgressOptionTickInterval(250*time.Millisecond),
+			prompt.ProgressOptionAppendAdditionalInfo(func() string {
+				v, suffix := prompt.AddSISuffix(float64(processed.Load()), true)
+				t, totalSuffix := prompt.AddSISuffix(float64(total), true)
+				return fmt.Sprintf("%.1f %sB of %.1f %sB", v, suffix, t, totalSuffix)
+			}),
+		)
+		bar.Set(0, uint64(total))
+		bar.Start()
+		defer bar.Finish()
+	}
+
+	for _, f := range files {
+		err := replayArchive(f.path, quiet, func(source string) *shipper.LineBuffer {
+			var fields map[string]string
+			if sourceField != "" {
+				fields = map[string]string{sourceField: source}
+			}
+			return newLineBuffer(fields)
+		}, func(n int) {
+			cur := processed.Add(int64(n))
+			if bar != nil {
+				bar.Update(uint64(cur))
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("error replaying %s: %w", f.path, err)
+		}
+	}
+
+	return nil
+}
+
+// replayArchive reads the lines of the file at path. Each file in an archive gets its own line buffer.
+func replayArchive(path string, quiet bool, linesFor func(source string) *shipper.LineBuffer, progress func(n int)) error {
+	// #nosec G304
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var reader io.Reader = &progressReader{reader: f, progress: progress}
+
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".tgz"):
+		name = strings.TrimSuffix(name, ".tgz") + ".tar.gz"
+		fallthrough
+	case strings.HasSuffix(name, ".gz"):
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+		name = strings.TrimSuffix(name, ".gz")
+	case strings.HasSuffix(name, ".zst"):
+		zr, err := zstd.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		reader = zr
+		name = strings.TrimSuffix(name, ".zst")
+	case strings.HasSuffix(name, ".bz2"):
+		reader = bzip2.NewReader(reader)
+		name = strings.TrimSuffix(name, ".bz2")
+	}
+
+	if !strings.HasSuffix(name, ".tar") {
+		return streamLines(reader, quiet, linesFor(path))
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := streamLines(tr, quiet, linesFor(path+":"+header.Name)); err != nil {
+			return err
+		}
+	}
+}
+
+// progressReader reports the number of bytes read from the underlying reader.
+type progressReader struct {
+	reader   io.Reader
+	progress func(n int)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.progress(n)
+	return n, err
+}
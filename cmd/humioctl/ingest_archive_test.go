@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/humio/cli/shipper"
+	"github.com/klauspost/compress/zstd"
+)
+
+type replayedLine struct {
+	source string
+	line   string
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarData(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	if err := w.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o700}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(files); i += 2 {
+		if err := w.WriteHeader(&tar.Header{Name: files[i], Typeflag: tar.TypeReg, Mode: 0o600, Size: int64(len(files[i+1]))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, files[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReplayArchive(t *testing.T) {
+	tests := []struct {
+		name string
+		data func(t *testing.T) []byte
+		want []replayedLine
+	}{
+		{
+			name: "plain.log",
+			data: func(*testing.T) []byte { return []byte("one\r\ntwo") },
+			want: []replayedLine{{"plain.log", "one"}, {"plain.log", "two"}},
+		},
+		{
+			name: "app.log.gz",
+			data: func(t *testing.T) []byte { return gzipData(t, []byte("one\ntwo\n")) },
+			want: []replayedLine{{"app.log.gz", "one"}, {"app.log.gz", "two"}},
+		},
+		{
+			name: "app.log.zst",
+			data: func(t *testing.T) []byte { return zstdData(t, []byte("one\n")) },
+			want: []replayedLine{{"app.log.zst", "one"}},
+		},
+		{
+			name: "logs.tar",
+			data: func(t *testing.T) []byte { return tarData(t, "dir/a.log", "a1\na2\n", "b.log", "b1\n") },
+			want: []replayedLine{{"logs.tar:dir/a.log", "a1"}, {"logs.tar:dir/a.log", "a2"}, {"logs.tar:b.log", "b1"}},
+		},
+		{
+			name: "logs.TGZ",
+			data: func(t *testing.T) []byte { return gzipData(t, tarData(t, "a.log", "a1\n")) },
+			want: []replayedLine{{"logs.TGZ:a.log", "a1"}},
+		},
+		{
+			name: "logs.tar.zst",
+			data: func(t *testing.T) []byte { return zstdData(t, tarData(t, "a.log", "a1\n")) },
+			want: []replayedLine{{"logs.tar.zst:a.log", "a1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, tt.name)
+			data := tt.data(t)
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []replayedLine
+			var read int
+			linesFor := func(source string) *shipper.LineBuffer {
+				rel, err := filepath.Rel(dir, source)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return &shipper.LineBuffer{MaxSize: 1024, Handle: func(line string, _ map[string]string) {
+					got = append(got, replayedLine{rel, line})
+				}}
+			}
+			if err := replayArchive(path, true, linesFor, func(n int) { read += n }); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			if read != len(data) {
+				t.Errorf("expected progress for %d bytes, got %d", len(data), read)
+			}
+		})
+	}
+}
+
+func TestReplayArchiveCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.gz")
+	if err := os.WriteFile(path, []byte("not gzip"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	linesFor := func(string) *shipper.LineBuffer {
+		return &shipper.LineBuffer{MaxSize: 1024, Handle: func(string, map[string]string) {}}
+	}
+	if err := replayArchive(path, true, linesFor, func(int) {}); err == nil {
+		t.Error("expected an error for a corrupt file")
+	}
+}
+
+func TestFindReplayFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"b.log", "sub/a.log", "c.log"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("line\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now, now.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{replayOrderName, []string{"b.log", "c.log", "sub/a.log"}},
+		{replayOrderMtime, []string{"b.log", "sub/a.log", "c.log"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			files, err := findReplayFiles(dir, tt.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range files {
+				rel, err := filepath.Rel(dir, f.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := findReplayFiles(dir, "size"); err == nil {
+		t.Error("expected an error for an unknown order")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/humio/cli/shipper"
+)
+
+// execResult is the outcome of a command run with --exec.
+type execResult struct {
+	command  []string
+	exitCode int
+	runtime  time.Duration
+}
+
+// runCommand runs command and sends each line of its stdout and stderr to a
+// handler with a stream field telling which one the line was written to.
+func runCommand(command []string, quiet bool, newLineBuffer func(fields map[string]string) *shipper.LineBuffer) (execResult, error) {
+	result := execResult{command: command}
+
+	// #nosec G204
+	child := exec.Command(command[0], command[1:]...)
+	child.Stdin = os.Stdin
+
+	stdout, err := child.StdoutPipe()
+	if err != nil {
+		return result, err
+	}
+	stderr, err := child.StderrPipe()
+	if err != nil {
+		return result, err
+	}
+
+	start := time.Now()
+	if err := child.Start(); err != nil {
+		return result, err
+	}
+
+	var wg sync.WaitGroup
+	streamErrs := make([]error, 2)
+	for i, stream := range []struct {
+		name   string
+		reader io.Reader
+		echo   io.Writer
+	}{
+		{"stdout", stdout, os.Stdout},
+		{"stderr", stderr, os.Stderr},
+	} {
+		reader := stream.reader
+		if !quiet {
+			reader = io.TeeReader(reader, stream.echo)
+		}
+		lines := newLineBuffer(map[string]string{"stream": stream.name})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			streamErrs[i] = streamLines(reader, true, lines)
+		}()
+	}
+
+	// The pipes must be drained before waiting for the command, as Wait closes them.
+	wg.Wait()
+	err = child.Wait()
+	result.runtime = time.Since(start)
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.exitCode = exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			result.exitCode = 128 + int(status.Signal())
+		}
+	case err != nil:
+		return result, err
+	}
+
+	return result, errors.Join(streamErrs...)
+}
+
+// summary returns the message and fields of the event recording how the command exited.
+func (r execResult) summary() (string, map[string]string) {
+	message := "Command '" + strings.Join(r.command, " ") + "' exited with code " + strconv.Itoa(r.exitCode) + " after " + r.runtime.Round(time.Millisecond).String()
+	fields := map[string]string{
+		"command":         strings.Join(r.command, " "),
+		"exit_code":       strconv.Itoa(r.exitCode),
+		"runtime_seconds": strconv.FormatFloat(r.runtime.Seconds(), 'f', 3, 64),
+	}
+	return message, fields
+}
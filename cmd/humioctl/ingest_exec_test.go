@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/humio/cli/shipper"
+)
+
+func TestRunCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	tests := []struct {
+		name     string
+		script   string
+		exitCode int
+		stdout   []string
+		stderr   []string
+	}{
+		{"success", "echo one; echo two >&2; echo three", 0, []string{"one", "three"}, []string{"two"}},
+		{"exit code", "echo failing >&2; exit 3", 3, nil, []string{"failing"}},
+		{"unterminated last line", "printf 'a\\nb'", 0, []string{"a", "b"}, nil},
+		{"signal", "echo before; kill -TERM $$", 128 + 15, []string{"before"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			lines := map[string][]string{}
+			newLineBuffer := func(fields map[string]string) *shipper.LineBuffer {
+				return &shipper.LineBuffer{MaxSize: 1024, Handle: func(line string, _ map[string]string) {
+					mu.Lock()
+					defer mu.Unlock()
+					lines[fields["stream"]] = append(lines[fields["stream"]], line)
+				}}
+			}
+
+			result, err := runCommand([]string{"sh", "-c", tt.script}, true, newLineBuffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.exitCode != tt.exitCode {
+				t.Errorf("expected exit code %d, got %d", tt.exitCode, result.exitCode)
+			}
+			if !reflect.DeepEqual(lines["stdout"], tt.stdout) || !reflect.DeepEqual(lines["stderr"], tt.stderr) {
+				t.Errorf("expected stdout %q and stderr %q, got %q and %q", tt.stdout, tt.stderr, lines["stdout"], lines["stderr"])
+			}
+		})
+	}
+}
+
+func TestRunCommandNotFound(t *testing.T) {
+	newLineBuffer := func(map[string]string) *shipper.LineBuffer {
+		return &shipper.LineBuffer{MaxSize: 1024, Handle: func(string, map[string]string) {}}
+	}
+	if _, err := runCommand([]string{"humioctl-test-no-such-command"}, true, newLineBuffer); err == nil {
+		t.Error("expected an error for a command that does not exist")
+	}
+}
+
+func TestExecResultSummary(t *testing.T) {
+	result := execResult{command: []string{"backup", "--full"}, exitCode: 2, runtime: 1500 * time.Millisecond}
+	message, fields := result.summary()
+
+	if want := "Command 'backup --full' exited with code 2 after 1.5s"; message != want {
+		t.Errorf("expected %q, got %q", want, message)
+	}
+	want := map[string]string{"command": "backup --full", "exit_code": "2", "runtime_seconds": "1.500"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("expected %v, got %v", want, fields)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/humio/cli/shipper"
+)
+
+// serveIngestMetrics serves the stats, keyed by destination, in the Prometheus text format on /metrics at addr until the returned server is closed.
+func serveIngestMetrics(addr string, stats func() map[string]shipper.Stats) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := shipper.WritePrometheus(w, stats()); err != nil {
+			log.Printf("Error writing metrics: %v", err)
+		}
+	})
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Error serving metrics: %v", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// logIngestStats logs a summary of the stats every interval until stop is closed.
+func logIngestStats(interval time.Duration, stats func() shipper.Stats, logger func(format string, v ...interface{}), stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			logIngestSummary(stats(), logger)
+		}
+	}
+}
+
+func logIngestSummary(s shipper.Stats, logger func(format string, v ...interface{})) {
+	// The send duration quantiles are left out until a batch has been sent.
+	var durations string
+	if s.SendDuration.Count > 0 {
+		durations = fmt.Sprintf(" p50=%.3fs p99=%.3fs", s.SendDuration.Quantile(0.5), s.SendDuration.Quantile(0.99))
+	}
+	logger("Ingest stats: received=%d sent=%d dropped=%d queued=%d batches=%d failed=%d retries=%d bytes=%d%s",
+		s.EventsReceived, s.EventsSent, s.EventsDropped, s.EventsQueued, s.BatchesSent, s.BatchesFailed, s.Retries, s.BytesSent,
+		durations)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/humio/cli/internal/api"
+	"github.com/humio/cli/shipper"
+	"github.com/spf13/cobra"
+)
+
+func newIngestReplayCmd() *cobra.Command {
+	var resend batchResender
+
+	cmd := &cobra.Command{
+		Use:   "replay [flags] <dead-letter-file>",
+		Short: "Send batches from a dead-letter file again.",
+		Long: `Sends the batches written to a dead-letter file by 'ingest --dead-letter'
+to the same endpoint they were originally sent to.
+
+If the batches were sent using an ingest token, the same token must be
+given with --ingest-token. Batches that fail again are appended to the
+file given by --dead-letter, so they can be replayed once more.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			resend.run(cmd, args[0], "dead-letter file", shipper.ReadDeadLetterRecords, nil)
+		},
+	}
+
+	cmd.Flags().StringVarP(&resend.ingestToken, "ingest-token", "i", "", "Use the specified ingest token instead of the API token.")
+	cmd.Flags().StringVar(&resend.deadLetterPath, "dead-letter", "", "Append batches that fail again to this file.")
+	cmd.Flags().StringVar(&resend.compression, "compress", "none", "Compress request bodies with 'gzip' or 'zstd'.")
+	cmd.Flags().IntVarP(&resend.retries, "retries", "r", 2, "Number of retries when Humio sending events.")
+	cmd.Flags().IntVar(&resend.retryMaxBackoffMs, "retry-max-backoff", 30000, "Max duration in milliseconds to wait between retries, unless Humio asks for a longer wait.")
+
+	return cmd
+}
+
+// batchResender sends recorded batches, such as those of a dead-letter file or a bundle, using the flags of the
+// command.
+type batchResender struct {
+	ingestToken       string
+	deadLetterPath    string
+	compression       string
+	retries           int
+	retryMaxBackoffMs int
+}
+
+// run sends the batches read from the file at path by read, after rewriting their URL with rewriteURL, if set.
+// Batches that fail are appended to the --dead-letter file. It exits with an error if any batch failed.
+func (r *batchResender) run(cmd *cobra.Command, path, description string, read func(r io.Reader, handle func(record shipper.DeadLetterRecord) error) error, rewriteURL func(url string) string) {
+	var opts []func(config *api.Config)
+	if r.ingestToken != "" {
+		opts = append(opts, func(config *api.Config) {
+			config.Token = r.ingestToken
+		})
+	}
+
+	client := NewApiClient(cmd, opts...)
+
+	retryPolicy := shipper.DefaultRetryPolicy()
+	retryPolicy.MaxDelay = time.Duration(r.retryMaxBackoffMs) * time.Millisecond
+
+	sender := &shipper.LogShipper{
+		APIClient:           client,
+		MaxAttemptsPerBatch: r.retries + 1,
+		RetryPolicy:         retryPolicy,
+		Logger:              log.New(cmd.ErrOrStderr(), "", log.LstdFlags).Printf,
+	}
+
+	var err error
+	sender.Compression, err = shipper.ParseCompression(r.compression)
+	exitOnError(cmd, err, "Error parsing --compress value")
+
+	var deadLetter *shipper.DeadLetterFile
+	if r.deadLetterPath != "" {
+		if r.deadLetterPath == path {
+			cmd.PrintErr("The --dead-letter file must not be the file being sent.\n")
+			os.Exit(1)
+		}
+		deadLetter, err = shipper.OpenDeadLetterFile(r.deadLetterPath)
+		exitOnError(cmd, err, "Error opening dead-letter file")
+		defer deadLetter.Close()
+	}
+
+	// #nosec G304
+	f, err := os.Open(path)
+	exitOnError(cmd, err, "Error opening "+description)
+	defer f.Close()
+
+	var sentBatches, sentEvents, failedBatches, failedEvents int
+	err = read(f, func(record shipper.DeadLetterRecord) error {
+		if rewriteURL != nil {
+			record.URL = rewriteURL(record.URL)
+		}
+
+		sendErr := sender.Resend(record)
+		if sendErr == nil {
+			sentBatches++
+			sentEvents += record.Events
+			return nil
+		}
+
+		failedBatches++
+		failedEvents += record.Events
+		cmd.PrintErrf("Error sending batch of %d events from %s: %v\n", record.Events, record.Time.Format(time.RFC3339), sendErr)
+
+		if deadLetter == nil {
+			return nil
+		}
+		record.Time = time.Now()
+		record.Error = sendErr.Error()
+		return deadLetter.Write(record)
+	})
+	exitOnError(cmd, err, "Error sending "+description)
+
+	cmd.Println(fmt.Sprintf("Sent %d events in %d batches.", sentEvents, sentBatches))
+	if failedBatches > 0 {
+		cmd.PrintErrf("Failed to send %d events in %d batches.\n", failedEvents, failedBatches)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/humio/cli/shipper"
+)
+
+// syslogListener receives syslog messages over UDP and TCP and hands each one
+// on along with fields for its sender and parsed header.
+type syslogListener struct {
+	addresses []string
+	// sourceFor returns the handler chain receiving the messages of a UDP socket or a TCP connection.
+	sourceFor func() *ingestSource
+
+	mu          sync.Mutex
+	connections map[net.Conn]struct{}
+}
+
+// run listens on all addresses until ctx is cancelled.
+func (l *syslogListener) run(ctx context.Context) error {
+	l.connections = map[net.Conn]struct{}{}
+
+	var closers []func() error
+	var wg sync.WaitGroup
+	closeAll := func() {
+		for _, c := range closers {
+			_ = c()
+		}
+	}
+
+	for _, address := range l.addresses {
+		u, err := url.Parse(address)
+		if err != nil || u.Host == "" {
+			closeAll()
+			return fmt.Errorf("invalid syslog address %q, expected e.g. udp://:5514 or tcp://:5514", address)
+		}
+
+		switch u.Scheme {
+		case "udp":
+			conn, err := net.ListenPacket("udp", u.Host)
+			if err != nil {
+				closeAll()
+				return err
+			}
+			closers = append(closers, conn.Close)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				l.serveUDP(conn)
+			}()
+		case "tcp":
+			listener, err := net.Listen("tcp", u.Host)
+			if err != nil {
+				closeAll()
+				return err
+			}
+			closers = append(closers, listener.Close)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				l.serveTCP(listener)
+			}()
+		default:
+			closeAll()
+			return fmt.Errorf("unsupported syslog protocol %q, expected udp or tcp", u.Scheme)
+		}
+		log.Printf("Listening for syslog messages on %s", address)
+	}
+
+	<-ctx.Done()
+
+	closeAll()
+	l.mu.Lock()
+	for conn := range l.connections {
+		_ = conn.Close()
+	}
+	l.mu.Unlock()
+	wg.Wait()
+
+	return nil
+}
+
+func (l *syslogListener) serveUDP(conn net.PacketConn) {
+	source := l.sourceFor()
+	defer source.Finish()
+
+	buf := make([]byte, 65536)
+	for {
+		n, sender, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error reading syslog message: %v", err)
+			continue
+		}
+		frame := bytes.TrimRight(buf[:n], "\r\n\x00")
+		if len(frame) == 0 {
+			continue
+		}
+		source.setFields(syslogFields(frame, sender))
+		source.lines.Write(frame)
+		source.lines.EndLine()
+	}
+}
+
+func (l *syslogListener) serveTCP(listener net.Listener) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error accepting syslog connection: %v", err)
+			continue
+		}
+
+		l.mu.Lock()
+		l.connections[conn] = struct{}{}
+		l.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			source := l.sourceFor()
+			defer source.Finish()
+			defer func() {
+				l.mu.Lock()
+				delete(l.connections, conn)
+				l.mu.Unlock()
+				_ = conn.Close()
+			}()
+
+			err := shipper.ReadSyslogFrames(conn, source.lines, func(head []byte) {
+				source.setFields(syslogFields(head, conn.RemoteAddr()))
+			})
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Error reading syslog messages from %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// syslogFields returns the fields of a message from its sender and the header in head, the start of the message.
+func syslogFields(head []byte, sender net.Addr) map[string]string {
+	fields := map[string]string{
+		"syslog.sender": senderHost(sender),
+	}
+
+	// Keep messages that are not valid syslog, they may still be useful.
+	msg, err := shipper.ParseSyslogMessage(string(head))
+	if err == nil {
+		fields["syslog.priority"] = strconv.Itoa(msg.Priority)
+		fields["syslog.facility"] = msg.Facility()
+		fields["syslog.severity"] = msg.Severity()
+		if msg.Hostname != "" {
+			fields["syslog.hostname"] = msg.Hostname
+		}
+		if msg.AppName != "" {
+			fields["syslog.appname"] = msg.AppName
+		}
+	}
+
+	return fields
+}
+
+func senderHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
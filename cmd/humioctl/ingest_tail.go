@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tailFingerprintBytes is the number of bytes from the start of a file used to
+// recognize it when resuming from a registry.
+const tailFingerprintBytes = 1024
+
+// fileTailer follows a set of files and glob patterns. New files matching the
+// patterns are picked up as they appear, and both rename and copytruncate
+// rotation are detected. Lines are only handed over once they are terminated
+// by a newline, except for the last line of a file that is rotated away.
+//
+// With a registry, the offset of a file is only saved once the lines before it
+// have been settled by the shippers, so lines that were read but not yet sent
+// or dropped are read again by a restarted ingest.
+type fileTailer struct {
+	patterns     []string
+	seekToEnd    bool
+	pollInterval time.Duration
+	registry     *tailRegistry
+	// sourceFor returns the handler chain receiving the lines of the file at path. It is called each time a
+	// file is opened, including when a file is rotated, and the chain is finished when the file is let go of.
+	sourceFor func(path string) *ingestSource
+	// checkpoint calls done once the lines handed on so far have been settled.
+	checkpoint func(done func())
+	// checkpointing is set while a checkpoint has not been called back, so checkpoints do not pile up while the
+	// shippers are behind. The next checkpoint includes the offsets skipped in the meantime.
+	checkpointing atomic.Bool
+
+	files map[string]*tailedFile
+}
+
+type tailedFile struct {
+	path   string
+	file   *os.File
+	info   os.FileInfo
+	reader *bufio.Reader
+	offset int64
+	// pending is the number of bytes read of a line that has not yet been terminated.
+	pending int64
+	source  *ingestSource
+	// ends holds the offset reached by each line given to source that may still be held by it, oldest first.
+	ends []int64
+	// safe is the offset up to which the lines have been handed on by source, and checkpointed is the safe
+	// offset of the last checkpoint, or -1.
+	safe         int64
+	checkpointed int64
+	// head holds the first bytes of the file, used to detect that it has been truncated and rewritten.
+	head []byte
+}
+
+// run follows the files until ctx is cancelled.
+func (t *fileTailer) run(ctx context.Context) error {
+	t.files = map[string]*tailedFile{}
+	defer t.closeAll()
+
+	first := true
+	for {
+		if err := t.discover(first); err != nil {
+			return err
+		}
+		first = false
+
+		for path, f := range t.files {
+			if !t.checkRotation(f) {
+				delete(t.files, path)
+				continue
+			}
+			t.readLines(f)
+		}
+
+		t.checkpointOffsets(false)
+		if err := t.saveRegistry(); err != nil {
+			log.Printf("Error saving tail registry: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(t.pollInterval):
+		}
+	}
+}
+
+// discover opens files matching the patterns that are not followed yet.
+func (t *fileTailer) discover(initial bool) error {
+	for _, pattern := range t.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid tail pattern %q: %w", pattern, err)
+		}
+		sort.Strings(matches)
+
+		for _, path := range matches {
+			if _, ok := t.files[path]; ok {
+				continue
+			}
+
+			f, err := t.open(path, initial)
+			if err != nil {
+				log.Printf("Could not tail %s: %v", path, err)
+				continue
+			}
+			if f != nil {
+				t.files[path] = f
+			}
+		}
+	}
+
+	return nil
+}
+
+// open starts following path. Files seen at startup are resumed from the
+// registry if their contents still match, or start at the end when seekToEnd
+// is set. Files appearing later are always read from the beginning.
+func (t *fileTailer) open(path string, initial bool) (*tailedFile, error) {
+	// #nosec G304
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		_ = file.Close()
+		return nil, nil
+	}
+
+	var offset int64
+	switch {
+	case t.registry != nil && t.registry.resumable(path, file, info.Size()):
+		offset = t.registry.offset(path)
+	case initial && t.seekToEnd:
+		offset = info.Size()
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	f := &tailedFile{
+		path:   path,
+		file:   file,
+		info:   info,
+		reader: bufio.NewReader(file),
+		offset: offset,
+	}
+	t.attach(f)
+	return f, nil
+}
+
+// attach starts a new handler chain for the file at its current offset,
+// recording the offset reached by each line given to it.
+func (t *fileTailer) attach(f *tailedFile) {
+	f.source = t.sourceFor(f.path)
+	f.ends, f.safe, f.checkpointed = nil, f.offset, -1
+
+	handle := f.source.lines.Handle
+	f.source.lines.Handle = func(line string, marks map[string]string) {
+		f.ends = append(f.ends, f.offset)
+		handle(line, marks)
+	}
+}
+
+// updateSafeOffset moves the safe offset past the lines that are no longer held by the handler chain.
+func (f *tailedFile) updateSafeOffset() {
+	held := f.source.buffered()
+	switch {
+	case held == 0:
+		f.safe = f.offset
+		f.ends = f.ends[:0]
+	case held < len(f.ends):
+		f.safe = f.ends[len(f.ends)-held-1]
+		f.ends = append(f.ends[:0], f.ends[len(f.ends)-held:]...)
+	}
+}
+
+func (t *fileTailer) readLines(f *tailedFile) {
+	for {
+		chunk, err := f.reader.ReadSlice('\n')
+		f.pending += int64(len(chunk))
+		if errors.Is(err, bufio.ErrBufferFull) {
+			f.source.lines.Write(chunk)
+			continue
+		}
+		if err != nil {
+			if len(chunk) > 0 {
+				f.source.lines.Write(chunk)
+			}
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Error reading %s: %v", f.path, err)
+			}
+			return
+		}
+
+		f.source.lines.Write(bytes.TrimRight(chunk, "\r\n"))
+		f.offset += f.pending
+		f.pending = 0
+		f.source.lines.EndLine()
+	}
+}
+
+// flushPartial hands over the buffered line, even if it has not been terminated.
+func (f *tailedFile) flushPartial() {
+	if f.pending == 0 {
+		return
+	}
+	f.source.lines.EndLine()
+	f.pending = 0
+}
+
+// finish hands over what is buffered for the file, even if the line has not been terminated.
+func (f *tailedFile) finish() {
+	f.flushPartial()
+	f.source.Finish()
+}
+
+// checkRotation reopens or rewinds a file that has been rotated. It returns
+// false if the file is gone and should no longer be followed.
+func (t *fileTailer) checkRotation(f *tailedFile) bool {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		// Renamed or deleted. Drain what is left of the old file; a new file at the same path will be
+		// picked up by discover.
+		t.readLines(f)
+		f.finish()
+		_ = f.file.Close()
+		t.forget(f.path)
+		return false
+	}
+
+	if !os.SameFile(info, f.info) {
+		// Rename rotation: drain the old file and continue with the new one from the beginning.
+		// A new handler chain is started, so the new file does not inherit the multi line event or CSV header of
+		// its predecessor.
+		t.readLines(f)
+		f.finish()
+		_ = f.file.Close()
+		// #nosec G304
+		file, err := os.Open(f.path)
+		if err != nil {
+			log.Printf("Could not reopen rotated file %s: %v", f.path, err)
+			t.forget(f.path)
+			return false
+		}
+		f.file, f.info, f.offset, f.head = file, info, 0, nil
+		f.reader.Reset(file)
+		t.attach(f)
+		t.forget(f.path)
+		return true
+	}
+
+	if info.Size() < f.offset+f.pending || f.headChanged() {
+		// Copytruncate rotation: the file was truncated in place, start over from the beginning.
+		f.finish()
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			log.Printf("Could not rewind truncated file %s: %v", f.path, err)
+			_ = f.file.Close()
+			t.forget(f.path)
+			return false
+		}
+		f.offset, f.head = 0, nil
+		f.reader.Reset(f.file)
+		t.attach(f)
+		t.forget(f.path)
+	}
+
+	return true
+}
+
+// headChanged reports whether the start of the file differs from what was read earlier.
+func (f *tailedFile) headChanged() bool {
+	buf := make([]byte, min(f.offset, tailFingerprintBytes))
+	n, _ := f.file.ReadAt(buf, 0)
+	buf = buf[:n]
+
+	if len(buf) < len(f.head) || !bytes.Equal(buf[:len(f.head)], f.head) {
+		return true
+	}
+
+	f.head = buf
+	return false
+}
+
+// forget removes the registry entry for path, so the offset of a rotated file is not applied to its successor.
+func (t *fileTailer) forget(path string) {
+	if t.registry != nil {
+		t.registry.forget(path)
+	}
+}
+
+// checkpointOffsets records the safe offset of each file, and updates the registry with them once the lines
+// before them have been settled. Unless last is true, nothing is done while an earlier checkpoint is pending.
+func (t *fileTailer) checkpointOffsets(last bool) {
+	if t.registry == nil || (t.checkpointing.Load() && !last) {
+		return
+	}
+
+	checkpoint := map[string]tailRegistryEntry{}
+	for path, f := range t.files {
+		f.updateSafeOffset()
+		if f.safe == f.checkpointed {
+			continue
+		}
+		fingerprint, length, err := fileFingerprint(f.path, f.safe)
+		if err != nil {
+			log.Printf("Error fingerprinting %s: %v", f.path, err)
+			continue
+		}
+		checkpoint[path] = tailRegistryEntry{
+			Offset:            f.safe,
+			Fingerprint:       fingerprint,
+			FingerprintLength: length,
+		}
+		f.checkpointed = f.safe
+	}
+	if len(checkpoint) == 0 {
+		return
+	}
+
+	update := t.registry.updater(checkpoint)
+	t.checkpointing.Store(true)
+	t.checkpoint(func() {
+		update()
+		t.checkpointing.Store(false)
+	})
+}
+
+// saveRegistry saves the registry if it has changed.
+func (t *fileTailer) saveRegistry() error {
+	if t.registry == nil {
+		return nil
+	}
+	return t.registry.save()
+}
+
+// closeAll hands over what is buffered for each file and takes a last checkpoint.
+func (t *fileTailer) closeAll() {
+	for _, f := range t.files {
+		f.source.Finish()
+	}
+	t.checkpointOffsets(true)
+	for _, f := range t.files {
+		_ = f.file.Close()
+	}
+}
+
+// tailRegistry records how far each tailed file has been read, so a restarted ingest can resume.
+type tailRegistry struct {
+	path  string
+	mu    sync.Mutex
+	dirty bool
+	// forgotten counts how often the entry of each path has been removed, so entries of earlier files at the
+	// path are not added back by a late checkpoint.
+	forgotten map[string]int
+	Files     map[string]tailRegistryEntry `json:"files"`
+}
+
+type tailRegistryEntry struct {
+	Offset int64 `json:"offset"`
+	// Fingerprint is a hash of the first FingerprintLength bytes of the file, used to tell whether the file at
+	// the path is still the one the offset belongs to.
+	Fingerprint       string `json:"fingerprint"`
+	FingerprintLength int64  `json:"fingerprintLength"`
+}
+
+func loadTailRegistry(path string) (*tailRegistry, error) {
+	r := &tailRegistry{path: path, forgotten: map[string]int{}, Files: map[string]tailRegistryEntry{}}
+
+	// #nosec G304
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("could not parse tail registry %s: %w", path, err)
+	}
+	if r.Files == nil {
+		r.Files = map[string]tailRegistryEntry{}
+	}
+
+	return r, nil
+}
+
+// resumable reports whether the recorded offset for path can be used for the opened file.
+func (r *tailRegistry) resumable(path string, file *os.File, size int64) bool {
+	r.mu.Lock()
+	entry, ok := r.Files[path]
+	r.mu.Unlock()
+	if !ok || entry.Offset > size {
+		return false
+	}
+
+	fingerprint, _, err := fileFingerprint(file.Name(), entry.FingerprintLength)
+	return err == nil && fingerprint == entry.Fingerprint
+}
+
+// offset returns the recorded offset for path.
+func (r *tailRegistry) offset(path string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.Files[path].Offset
+}
+
+func (r *tailRegistry) forget(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.forgotten[path]++
+	if _, ok := r.Files[path]; ok {
+		delete(r.Files, path)
+		r.dirty = true
+	}
+}
+
+// updater returns a function recording the entries, except for the paths forgotten in the meantime.
+func (r *tailRegistry) updater(entries map[string]tailRegistryEntry) func() {
+	r.mu.Lock()
+	forgotten := make(map[string]int, len(entries))
+	for path := range entries {
+		forgotten[path] = r.forgotten[path]
+	}
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		for path, entry := range entries {
+			if r.forgotten[path] == forgotten[path] {
+				r.Files[path] = entry
+				r.dirty = true
+			}
+		}
+	}
+}
+
+// save writes the registry to disk if it has changed.
+func (r *tailRegistry) save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, r.path); err != nil {
+		return err
+	}
+
+	r.dirty = false
+	return nil
+}
+
+// fileFingerprint hashes the first min(limit, tailFingerprintBytes) bytes of the file.
+func fileFingerprint(path string, limit int64) (string, int64, error) {
+	if limit > tailFingerprintBytes {
+		limit = tailFingerprintBytes
+	}
+
+	// #nosec G304
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.CopyN(h, f, limit)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/humio/cli/shipper"
+)
+
+func TestFileTailerKeepsOneCheckpointPending(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := loadTailRegistry(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pending []func()
+	tailer := &fileTailer{
+		patterns: []string{path},
+		registry: registry,
+		sourceFor: func(string) *ingestSource {
+			return &ingestSource{lines: &shipper.LineBuffer{MaxSize: 1024, Handle: func(string, map[string]string) {}}}
+		},
+		checkpoint: func(done func()) { pending = append(pending, done) },
+		files:      map[string]*tailedFile{},
+	}
+	poll := func() {
+		t.Helper()
+		if err := tailer.discover(true); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range tailer.files {
+			tailer.readLines(f)
+		}
+		tailer.checkpointOffsets(false)
+	}
+	appendLine := func(line string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(line + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	poll()
+	appendLine("second")
+	poll()
+	appendLine("third")
+	poll()
+	if len(pending) != 1 {
+		t.Fatalf("expected one checkpoint while the first is pending, got %d", len(pending))
+	}
+
+	pending[0]()
+	if offset := registry.offset(path); offset != int64(len("first\n")) {
+		t.Errorf("expected the offset of the first checkpoint, got %d", offset)
+	}
+
+	poll()
+	if len(pending) != 2 {
+		t.Fatalf("expected a new checkpoint once the first was called back, got %d", len(pending))
+	}
+	pending[1]()
+	if offset := registry.offset(path); offset != int64(len("first\nsecond\nthird\n")) {
+		t.Errorf("expected the offsets skipped while the first checkpoint was pending to be included, got %d", offset)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/humio/cli/internal/viperkey"
+	"github.com/spf13/viper"
+)
+
+func TestNewIngestDestinations(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set(viperkey.Address, "http://local.example.com/")
+	viper.Set(viperkey.Token, "local-token")
+	viper.Set(viperkey.Profiles, map[string]interface{}{
+		"eu": map[string]interface{}{viperkey.Address: "http://eu.example.com/", viperkey.Token: "eu-token"},
+	})
+
+	destinations, err := newIngestDestinations("", []string{"logs", "eu:audit"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ name, repo, address, token string }{
+		{"logs", "logs", "http://local.example.com/", "local-token"},
+		{"eu:audit", "audit", "http://eu.example.com/", "eu-token"},
+	}
+	if len(destinations) != len(want) {
+		t.Fatalf("expected %d destinations, got %d", len(want), len(destinations))
+	}
+	for i, w := range want {
+		d := destinations[i]
+		if d.name != w.name || d.repo != w.repo || d.client.Address().String() != w.address || d.client.Token() != w.token {
+			t.Errorf("expected %+v, got %s %s %s %s", w, d.name, d.repo, d.client.Address(), d.client.Token())
+		}
+	}
+
+	destinations, err = newIngestDestinations("", []string{"eu:audit"}, "ingest-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := destinations[0]; d.client.Address().String() != "http://eu.example.com/" || d.client.Token() != "ingest-token" {
+		t.Errorf("expected the ingest token for the cluster of the profile, got %s %s", d.client.Address(), d.client.Token())
+	}
+
+	destinations, err = newIngestDestinations("logs", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := destinations[0]; d.name != "" || d.repo != "logs" || d.client.Token() != "local-token" {
+		t.Errorf("expected the repo argument with the current profile, got %q %q %s", d.name, d.repo, d.client.Token())
+	}
+
+	if _, err := newIngestDestinations("", []string{"missing:logs"}, ""); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+func TestIngestDestinationURL(t *testing.T) {
+	tests := []struct {
+		hec, structured, ingestToken bool
+		want                         string
+	}{
+		{want: "api/v1/repositories/logs/ingest-messages"},
+		{structured: true, want: "api/v1/repositories/logs/ingest"},
+		{ingestToken: true, want: "api/v1/ingest/humio-unstructured"},
+		{structured: true, ingestToken: true, want: "api/v1/ingest/humio-structured"},
+		{hec: true, ingestToken: true, want: "api/v1/ingest/hec"},
+	}
+
+	d := ingestDestination{name: "eu:logs", repo: "logs"}
+	for _, tt := range tests {
+		if got := d.ingestURL(tt.hec, tt.structured, tt.ingestToken); got != tt.want {
+			t.Errorf("ingestURL(%t, %t, %t): expected %s, got %s", tt.hec, tt.structured, tt.ingestToken, tt.want, got)
+		}
+	}
+}
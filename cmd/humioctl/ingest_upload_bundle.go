@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/humio/cli/shipper"
+	"github.com/spf13/cobra"
+)
+
+func newIngestUploadBundleCmd() *cobra.Command {
+	var resend batchResender
+
+	cmd := &cobra.Command{
+		Use:   "upload-bundle [flags] <bundle-file> <repo>",
+		Short: "Send the requests written to a bundle file.",
+		Long: `Sends the requests written to a bundle file by 'ingest --output-bundle'
+to the repository <repo>, with the usual retries.
+
+If the bundle was written for an ingest token, the token must be given
+with --ingest-token, and the requests are sent to the repository of the
+token. Requests that fail are appended to the file given by --dead-letter,
+so they can be sent again using 'ingest replay'.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			bundlePath, repo := args[0], args[1]
+			resend.run(cmd, bundlePath, "bundle", shipper.ReadBundle, func(url string) string {
+				return bundleURLForRepo(url, repo)
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&resend.ingestToken, "ingest-token", "i", "", "Use the specified ingest token instead of the API token.")
+	cmd.Flags().StringVar(&resend.deadLetterPath, "dead-letter", "", "Append batches that could not be sent to this file.")
+	cmd.Flags().StringVar(&resend.compression, "compress", "none", "Compress request bodies with 'gzip' or 'zstd'.")
+	cmd.Flags().IntVarP(&resend.retries, "retries", "r", 2, "Number of retries when Humio sending events. Requests rejected because of e.g. an invalid token or parser are not retried.")
+	cmd.Flags().IntVar(&resend.retryMaxBackoffMs, "retry-max-backoff", 30000, "Max duration in milliseconds to wait between retries, unless Humio asks for a longer wait.")
+
+	return cmd
+}
+
+// bundleURLForRepo replaces the repository in a URL of the repository ingest API with repo.
+// URLs of the ingest token APIs are returned as they are.
+func bundleURLForRepo(bundleURL, repo string) string {
+	const prefix = "api/v1/repositories/"
+	rest, ok := strings.CutPrefix(bundleURL, prefix)
+	if !ok {
+		return bundleURL
+	}
+	_, endpoint, ok := strings.Cut(rest, "/")
+	if !ok {
+		return bundleURL
+	}
+	return prefix + repo + "/" + endpoint
+}
@@ -232,6 +232,40 @@ func newApiClientE(opts ...func(config *api.Config)) (*api.Client, error) {
 	return api.NewClient(config), nil
 }
 
+// newApiClientForProfileE creates a client for the saved profile with the given name,
+// or for the current configuration if the name is empty.
+func newApiClientForProfileE(profileName string, opts ...func(config *api.Config)) (*api.Client, error) {
+	if profileName == "" {
+		return newApiClientE(opts...)
+	}
+
+	profile, err := loadProfile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	parsedURL, err := url.Parse(profile.address)
+	if err != nil {
+		return nil, err
+	}
+
+	profileOpt := func(config *api.Config) {
+		config.Address = parsedURL
+		config.Token = profile.token
+		config.CACertificatePEM = profile.caCertificate
+		config.Insecure = profile.insecure
+	}
+
+	return newApiClientE(append([]func(config *api.Config){profileOpt}, opts...)...)
+}
+
+// splitProfileRepo splits "profile:repo" into its parts. The profile is empty if not given.
+func splitProfileRepo(s string) (profile, repo string) {
+	if profile, repo, ok := strings.Cut(s, ":"); ok {
+		return profile, repo
+	}
+	return "", s
+}
+
 func main() {
 	SetVersion(version, commit, date)
 	err := rootCmd.Execute()
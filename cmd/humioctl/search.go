@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -29,16 +30,112 @@ func newSearchCmd() *cobra.Command {
 		noWrap       bool
 		noProgress   bool
 		jsonProgress bool
+		outputFormat string
+		queryArgs    []string
+		queryArgFile string
+		timezone     string
+		query        string
+		queryFile    string
+		queryVars    []string
+		renderOnly   bool
+		aggregates   string
 	)
 
 	cmd := &cobra.Command{
-		Use:   "search [flags] <repo> <query>",
+		Use:   "search [flags] <repo> [<query>]",
 		Short: "Search",
-		Args:  cobra.ExactArgs(2),
+		Long: `Searches the repository <repo> using <query>.
+
+Several repositories, also in the clusters of other saved profiles, can
+be searched at once by giving a comma separated list of '<repo>' or
+'<profile>:<repo>'. Events are merged in order of their timestamps, with
+a #repo field telling which repository they are from. Aggregates are
+shown for each repository, or in one table with a #repo column when
+--aggregates=concat is given or --output is used:
+
+  $ humioctl search 'logs,eu:logs,us:logs' 'error' --fmt='{@timestamp} {#repo} {@rawstring}'
+
+Instead of as an argument, the query can be read from a file using
+-q @<file> or --query-file=<file>. Lines such as '#include "common.lql"'
+are replaced by the contents of the named file, relative to the including
+file. The query is a Go template, so values given with --var can be
+inserted with {{.name}}, or as a quoted string literal with
+{{quote .name}}:
+
+  $ humioctl search -q @queries/failed-logins.lql --var user=alice myrepo
+
+Use --render-only to print the query instead of running it, in which
+case <repo> can be left out:
+
+  $ humioctl search --render-only -q @queries/failed-logins.lql --var user=alice
+
+A repository named 'export' is taken for the export subcommand, unless
+-- is put before it:
+
+  $ humioctl search -- export 'user=alice'`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if renderOnly {
+				return cobra.MaximumNArgs(2)(cmd, args)
+			}
+			return cobra.RangeArgs(1, 2)(cmd, args)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			repository := args[0]
-			queryString := args[1]
-			client := NewApiClient(cmd)
+			var repository string
+			if len(args) > 0 {
+				repository, args = args[0], args[1:]
+			}
+			queryString, err := searchQueryString(args, query, queryFile, queryVars)
+			exitOnError(cmd, err, "Error reading query")
+
+			if renderOnly {
+				fmt.Fprintln(cmd.OutOrStdout(), queryString)
+				return
+			}
+
+			var client *api.Client
+			var targets []*searchTarget
+			if isMultiTargetSearch(repository) {
+				targets, err = parseSearchTargets(repository)
+				exitOnError(cmd, err, "Error parsing repositories")
+				if !cmd.Flags().Changed("fmt") {
+					fmtStr = "{@timestamp} {#repo} {@rawstring}"
+				}
+			} else {
+				client = NewApiClient(cmd)
+			}
+
+			if aggregates != aggregatesPerSource && aggregates != aggregatesConcat {
+				cmd.PrintErrf("Unknown --aggregates value %q, expected %q or %q.\n", aggregates, aggregatesPerSource, aggregatesConcat)
+				os.Exit(1)
+			}
+
+			var outputPrinter *searchOutputPrinter
+			if outputFormat != "" {
+				outputPrinter, err = newSearchOutputPrinter(cmd.OutOrStdout(), outputFormat)
+				exitOnError(cmd, err, "Error parsing --output value")
+			}
+			if outputFormat == searchOutputJSON && live {
+				cmd.PrintErrln("Cannot use --output=json with --live, as the results are printed as they arrive. Use --output=ndjson instead.")
+				os.Exit(1)
+			}
+
+			arguments, err := parseQueryArguments(queryArgs, queryArgFile)
+			exitOnError(cmd, err, "Error parsing query arguments")
+
+			var timezoneOffset *int
+			if timezone != "" {
+				// A live search has no fixed end, so only its start is considered.
+				windowEnd := end
+				if live {
+					windowEnd = start
+				}
+				offset, warning, err := timezoneOffsetMinutes(timezone, start, windowEnd, time.Now())
+				exitOnError(cmd, err, "Error parsing --timezone value")
+				if warning != "" {
+					cmd.PrintErrln(warning)
+				}
+				timezoneOffset = &offset
+			}
 
 			ctx := contextCancelledOnInterrupt(context.Background())
 
@@ -51,17 +148,44 @@ func newSearchCmd() *cobra.Command {
 			}
 
 			// run in lambda func to be able to defer and delete the query job
-			err := func() error {
-				id, err := client.QueryJobs().Create(repository, api.Query{
+			err = func() error {
+				query := api.Query{
 					QueryString:                queryString,
 					Start:                      start,
 					End:                        end,
 					Live:                       live,
+					TimezoneOffset:             timezoneOffset,
+					Arguments:                  arguments,
 					ShowQueryEventDistribution: true,
-				})
+				}
 
-				if err != nil {
-					return err
+				var poller interface {
+					WaitAndPollContext(ctx context.Context) (api.QueryResult, error)
+				}
+
+				if targets != nil {
+					multiPoller, err := startMultiQuery(targets, query)
+					if err != nil {
+						return err
+					}
+					defer multiPoller.delete()
+					poller = multiPoller
+				} else {
+					id, err := client.QueryJobs().Create(repository, query)
+					if err != nil {
+						return err
+					}
+
+					defer func(id string) {
+						// Humio will eventually delete the query when we stop polling and we can't do much about errors here.
+						_ = client.QueryJobs().Delete(repository, id)
+					}(id)
+
+					poller = &queryJobPoller{
+						queryJobs:  client.QueryJobs(),
+						repository: repository,
+						id:         id,
+					}
 				}
 
 				var progress *queryResultProgressBar
@@ -69,18 +193,7 @@ func newSearchCmd() *cobra.Command {
 					progress = newQueryResultProgressBar()
 				}
 
-				defer func(id string) {
-					// Humio will eventually delete the query when we stop polling and we can't do much about errors here.
-					_ = client.QueryJobs().Delete(repository, id)
-				}(id)
-
-				var result api.QueryResult
-				poller := queryJobPoller{
-					queryJobs:  client.QueryJobs(),
-					repository: repository,
-					id:         id,
-				}
-				result, err = poller.WaitAndPollContext(ctx)
+				result, err := poller.WaitAndPollContext(ctx)
 
 				if err != nil {
 					return err
@@ -90,9 +203,14 @@ func newSearchCmd() *cobra.Command {
 					print(api.QueryResult)
 				}
 
-				if result.Metadata.IsAggregate {
+				switch {
+				case outputPrinter != nil:
+					printer = outputPrinter
+				case result.Metadata.IsAggregate && targets != nil && aggregates == aggregatesPerSource:
+					printer = newPerSourceAggregatePrinter(cmd.OutOrStdout(), noWrap, targets)
+				case result.Metadata.IsAggregate:
 					printer = newAggregatePrinter(cmd.OutOrStdout(), noWrap)
-				} else {
+				default:
 					printer = newEventListPrinter(cmd.OutOrStdout(), fmtStr)
 				}
 
@@ -101,7 +219,7 @@ func newSearchCmd() *cobra.Command {
 						progress.Update(result)
 					}
 					if jsonProgress {
-						jsonProgress, _ := printQueryResultProgressJson(result, args, startMillis)
+						jsonProgress, _ := printQueryResultProgressJson(result, repository, queryString, startMillis)
 						fmt.Printf("%s\n", jsonProgress)
 					}
 					result, err = poller.WaitAndPollContext(ctx)
@@ -116,7 +234,7 @@ func newSearchCmd() *cobra.Command {
 				}
 
 				if jsonProgress {
-					jsonProgress, _ := printQueryResultProgressJson(result, args, startMillis)
+					jsonProgress, _ := printQueryResultProgressJson(result, repository, queryString, startMillis)
 					fmt.Printf("%s\n", jsonProgress)
 				}
 
@@ -139,11 +257,12 @@ func newSearchCmd() *cobra.Command {
 				return nil
 			}()
 
-			if err == context.Canceled {
+			if errors.Is(err, context.Canceled) {
 				err = nil
 			}
 
-			if queryError, ok := err.(api.QueryError); ok {
+			var queryError api.QueryError
+			if errors.As(err, &queryError) {
 				cmd.PrintErrf("There was an error in your query string:\n\n%s\n", queryError.Error())
 				os.Exit(1)
 			}
@@ -159,10 +278,25 @@ func newSearchCmd() *cobra.Command {
 		"Insert fields by wrapping field names in brackets, e.g. {@timestamp}\n"+
 		"Limited format modifiers are supported such as {@timestamp:40} which will right align and left pad @timestamp to 40 characters.\n"+
 		"{@timestamp:-40} left aligns and right pads to 40 characters.")
+	cmd.Flags().StringVarP(&query, "query", "q", "", "The query, instead of as an argument. Use @<file> to read it from a file.")
+	cmd.Flags().StringVar(&queryFile, "query-file", "", "Read the query from this file.")
+	cmd.Flags().StringArrayVar(&queryVars, "var", nil, "Set the template variable <name> of a query read from a file, as name=value. Can be given multiple times.")
+	cmd.Flags().BoolVar(&renderOnly, "render-only", false, "Print the query instead of running it.")
+	cmd.Flags().StringVar(&aggregates, "aggregates", aggregatesPerSource, "When searching several repositories, show aggregates 'per-source' or 'concat' them into one table with a #repo column.")
+	cmd.Flags().StringArrayVar(&queryArgs, "arg", nil, "Set the query parameter <name>, written as ?name in the query, as name=value. Can be given multiple times.")
+	cmd.Flags().StringVar(&queryArgFile, "arg-file", "", "Read query parameters from this file, one name=value pair per line. Values given with --arg take precedence.")
+	cmd.Flags().StringVar(&timezone, "timezone", "", "The time zone of the query, such as 'Europe/Copenhagen', used e.g. for the buckets of timechart. Defaults to UTC.")
+	cmd.Flags().StringVar(&outputFormat, "output", "", "Print the results as 'ndjson', 'json', 'csv' or 'tsv' instead of using --fmt or a table.\n"+
+		"Fields are ordered as in the query result. Nested values are written as JSON in csv and tsv.\n"+
+		"In live searches new events are printed as they arrive, and aggregates are printed in full each time they are updated.\n"+
+		"In csv and tsv the header is printed again when events with new fields arrive, and each update of an aggregate\n"+
+		"is printed with its own header after an empty line. 'json' cannot be used with --live.")
 	cmd.Flags().BoolVarP(&noWrap, "no-wrap", "n", false, "Do not autowrap long strings.")
 	cmd.Flags().BoolVar(&noProgress, "no-progress", false, "Do not should progress information.")
 	cmd.Flags().BoolVar(&jsonProgress, "json-progress", false, "Print progress in json format. This disables progress and output, useful for logging search metadata.")
 
+	cmd.AddCommand(newSearchExportCmd())
+
 	return cmd
 }
 
@@ -253,7 +387,7 @@ type queryResultProgressJson struct {
 	Done        bool    `json:"done"`
 }
 
-func printQueryResultProgressJson(result api.QueryResult, args []string, startMillis int64) (string, error) {
+func printQueryResultProgressJson(result api.QueryResult, repository, queryString string, startMillis int64) (string, error) {
 	var epsValue, bpsValue float64
 
 	if result.Metadata.TimeMillis > 0 {
@@ -266,8 +400,8 @@ func printQueryResultProgressJson(result api.QueryResult, args []string, startMi
 	jsonResult := &queryResultProgressJson{
 		Timestamp:   timestamp,
 		StartMillis: startMillis,
-		Repo:        args[0],
-		QueryString: args[1],
+		Repo:        repository,
+		QueryString: queryString,
 		Start:       result.Metadata.QueryStart,
 		End:         result.Metadata.QueryEnd,
 		TotalWork:   result.Metadata.TotalWork,
@@ -385,9 +519,23 @@ func (p *eventListPrinter) initPrintFunc() {
 }
 
 func (p *eventListPrinter) print(result api.QueryResult) {
-	sort.Slice(result.Events, func(i, j int) bool {
-		tsI, hasTsI := result.Events[i]["@timestamp"].(float64)
-		tsJ, hasTsJ := result.Events[j]["@timestamp"].(float64)
+	sortEventsByTimestamp(result.Events)
+
+	for _, e := range result.Events {
+		id, hasID := e["@id"].(string)
+		if hasID && !p.printedIds[id] {
+			p.printEventFunc(p.w, e)
+			p.printedIds[id] = true
+		} else if !hasID {
+			p.printEventFunc(p.w, e)
+		}
+	}
+}
+
+func sortEventsByTimestamp(events []map[string]interface{}) {
+	sort.Slice(events, func(i, j int) bool {
+		tsI, hasTsI := events[i]["@timestamp"].(float64)
+		tsJ, hasTsJ := events[j]["@timestamp"].(float64)
 
 		switch {
 		case hasTsI && hasTsJ:
@@ -400,16 +548,6 @@ func (p *eventListPrinter) print(result api.QueryResult) {
 			return false
 		}
 	})
-
-	for _, e := range result.Events {
-		id, hasID := e["@id"].(string)
-		if hasID && !p.printedIds[id] {
-			p.printEventFunc(p.w, e)
-			p.printedIds[id] = true
-		} else if !hasID {
-			p.printEventFunc(p.w, e)
-		}
-	}
 }
 
 type aggregatePrinter struct {
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/humio/cli/internal/api"
+	"github.com/spf13/cobra"
+)
+
+const searchExportManifestName = "manifest.json"
+
+// minExportChunk is the shortest time range of a chunk.
+const minExportChunk = time.Second
+
+func newSearchExportCmd() *cobra.Command {
+	var (
+		start         string
+		end           string
+		outDir        string
+		chunkDuration time.Duration
+		concurrency   int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export [flags] <repo> <query>",
+		Short: "Export all events matching a query to files.",
+		Long: `Exports all events matching <query> between --start and --end to
+gzip compressed NDJSON files in the directory given by --out.
+
+The time range is split into chunks of --chunk, which are searched
+--concurrency at a time and written to a file each. The events of a
+chunk are streamed to its file as Humio finds them, so there is no limit
+on the number of events in a chunk, and they are not sorted. The
+progress is recorded in manifest.json in the directory, so an
+interrupted export continues where it stopped when run again with the
+same repository, query and --chunk:
+
+  $ humioctl search export --start=2024-01-01T00:00:00Z --end=2024-02-01T00:00:00Z --out=export/ myrepo 'user=alice'
+
+The start and end are given as RFC 3339 times, milliseconds since the
+epoch, or times relative to now such as '7d'. An export started with
+relative times is continued with the time range it was started with.
+
+To search a repository named 'export' rather than run this command, put
+-- before the repository:
+
+  $ humioctl search -- export 'user=alice'`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			repository, queryString := args[0], args[1]
+
+			now := time.Now()
+			startTime, err := parseSearchTime(start, now)
+			exitOnError(cmd, err, "Error parsing --start value")
+			endTime, err := parseSearchTime(end, now)
+			exitOnError(cmd, err, "Error parsing --end value")
+			if !startTime.Before(endTime) {
+				cmd.PrintErrln("The --start time must be before the --end time.")
+				os.Exit(1)
+			}
+			if chunkDuration < minExportChunk {
+				cmd.PrintErrf("The --chunk duration must be at least %s.\n", minExportChunk)
+				os.Exit(1)
+			}
+			if concurrency < 1 {
+				concurrency = 1
+			}
+
+			client := NewApiClient(cmd)
+
+			err = os.MkdirAll(outDir, 0o750)
+			exitOnError(cmd, err, "Error creating output directory")
+
+			manifest, err := loadSearchExportManifest(outDir, searchExportManifest{
+				Repository:  repository,
+				QueryString: queryString,
+				Start:       startTime.UnixMilli(),
+				End:         endTime.UnixMilli(),
+				ChunkMillis: chunkDuration.Milliseconds(),
+			}, !isAbsoluteSearchTime(start) || !isAbsoluteSearchTime(end))
+			exitOnError(cmd, err, "Error loading export manifest")
+			if manifest.Start != startTime.UnixMilli() || manifest.End != endTime.UnixMilli() {
+				cmd.PrintErrf("Continuing the export from %s to %s.\n", formatMillis(manifest.Start), formatMillis(manifest.End))
+			}
+
+			exporter := &searchExporter{
+				queryJobs:  client.QueryJobs(),
+				repository: repository,
+				query:      queryString,
+				outDir:     outDir,
+				manifest:   manifest,
+			}
+
+			ctx := contextCancelledOnInterrupt(context.Background())
+			err = exporter.run(ctx, concurrency, func(chunk searchExportChunk) {
+				cmd.PrintErrf("Exported %d events from %s to %s.\n", chunk.Events, formatMillis(chunk.Start), formatMillis(chunk.End))
+			})
+			if errors.Is(err, context.Canceled) {
+				cmd.PrintErrln("Export interrupted. Run the same command again to continue it.")
+				os.Exit(1)
+			}
+			var queryError api.QueryError
+			if errors.As(err, &queryError) {
+				cmd.PrintErrf("There was an error in your query string:\n\n%s\n", queryError.Error())
+				os.Exit(1)
+			}
+			exitOnError(cmd, err, "Error exporting events")
+
+			var events int
+			for _, chunk := range manifest.Chunks {
+				events += chunk.Events
+			}
+			cmd.Println(fmt.Sprintf("Exported %d events in %d files to %s.", events, len(manifest.Chunks), outDir))
+		},
+	}
+
+	cmd.Flags().StringVarP(&start, "start", "s", "", "Export start time.")
+	cmd.Flags().StringVarP(&end, "end", "e", "now", "Export end time.")
+	cmd.Flags().StringVar(&outDir, "out", "", "The directory to write the files and manifest to.")
+	cmd.Flags().DurationVar(&chunkDuration, "chunk", time.Hour, "The time range of each file.")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 4, "Max number of searches to run at the same time.")
+	_ = cmd.MarkFlagRequired("start")
+	_ = cmd.MarkFlagRequired("out")
+
+	return cmd
+}
+
+// searchExportManifest records the chunks of an export, so it can be resumed.
+type searchExportManifest struct {
+	Repository  string              `json:"repository"`
+	QueryString string              `json:"queryString"`
+	Start       int64               `json:"start"`
+	End         int64               `json:"end"`
+	ChunkMillis int64               `json:"chunkMillis"`
+	Chunks      []searchExportChunk `json:"chunks"`
+}
+
+type searchExportChunk struct {
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	File   string `json:"file"`
+	Events int    `json:"events"`
+	Done   bool   `json:"done"`
+}
+
+// loadSearchExportManifest returns the manifest in dir, or a new one for the export if there is none. It is an error
+// if the manifest is for a different export. If relative is true, the time range of the export was given relative
+// to now, and the manifest is used regardless of its time range.
+func loadSearchExportManifest(dir string, export searchExportManifest, relative bool) (*searchExportManifest, error) {
+	// #nosec G304
+	data, err := os.ReadFile(filepath.Join(dir, searchExportManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		for start := export.Start; start < export.End; start += export.ChunkMillis {
+			chunk := searchExportChunk{Start: start, End: min(start+export.ChunkMillis, export.End)}
+			chunk.File = fmt.Sprintf("%d-%d.ndjson.gz", chunk.Start, chunk.End)
+			export.Chunks = append(export.Chunks, chunk)
+		}
+		return &export, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest searchExportManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", searchExportManifestName, err)
+	}
+	if manifest.Repository != export.Repository || manifest.QueryString != export.QueryString || manifest.ChunkMillis != export.ChunkMillis {
+		return nil, fmt.Errorf("the directory contains an export of another repository, query or chunk duration")
+	}
+	if !relative && (manifest.Start != export.Start || manifest.End != export.End) {
+		return nil, fmt.Errorf("the directory contains an export from %s to %s", formatMillis(manifest.Start), formatMillis(manifest.End))
+	}
+	return &manifest, nil
+}
+
+type searchExporter struct {
+	queryJobs  *api.QueryJobs
+	repository string
+	query      string
+	outDir     string
+
+	mu       sync.Mutex
+	manifest *searchExportManifest
+}
+
+// run exports the chunks not yet done, calling done after each, and stops at the first error.
+func (e *searchExporter) run(ctx context.Context, concurrency int, done func(chunk searchExportChunk)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := e.saveManifest(); err != nil {
+		return err
+	}
+
+	var remaining []int
+	for index, chunk := range e.manifest.Chunks {
+		if !chunk.Done {
+			remaining = append(remaining, index)
+		}
+	}
+
+	pending := make(chan int)
+	var wg sync.WaitGroup
+	var firstErr error
+	var errOnce sync.Once
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range pending {
+				chunk, err := e.exportChunk(ctx, index)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				done(chunk)
+			}
+		}()
+	}
+
+feed:
+	for _, index := range remaining {
+		select {
+		case pending <- index:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// exportChunk writes the events of a chunk to a temporary file, which is renamed once it is complete.
+func (e *searchExporter) exportChunk(ctx context.Context, index int) (searchExportChunk, error) {
+	e.mu.Lock()
+	chunk := e.manifest.Chunks[index]
+	e.mu.Unlock()
+
+	path := filepath.Join(e.outDir, chunk.File)
+	tmpPath := path + ".tmp"
+
+	// #nosec G304
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return chunk, err
+	}
+	defer os.Remove(tmpPath)
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	gz := gzip.NewWriter(bw)
+
+	events, err := e.exportRange(ctx, chunk.Start, chunk.End, gz)
+	if err != nil {
+		return chunk, err
+	}
+	if err := gz.Close(); err != nil {
+		return chunk, err
+	}
+	if err := bw.Flush(); err != nil {
+		return chunk, err
+	}
+	if err := f.Sync(); err != nil {
+		return chunk, err
+	}
+	if err := f.Close(); err != nil {
+		return chunk, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return chunk, err
+	}
+
+	e.mu.Lock()
+	chunk.Events = events
+	chunk.Done = true
+	e.manifest.Chunks[index] = chunk
+	e.mu.Unlock()
+
+	return chunk, e.saveManifest()
+}
+
+// exportRange writes the events between start and end to w as NDJSON, as they are returned by the search.
+func (e *searchExporter) exportRange(ctx context.Context, start, end int64, w io.Writer) (int, error) {
+	body, err := e.queryJobs.StreamContext(ctx, e.repository, e.query, start, end)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	lines := &lineCountingWriter{w: w}
+	if _, err := io.Copy(lines, body); err != nil {
+		return 0, fmt.Errorf("error reading the events from %s to %s: %w", formatMillis(start), formatMillis(end), err)
+	}
+	if err := lines.endLine(); err != nil {
+		return 0, err
+	}
+	return lines.lines, nil
+}
+
+// lineCountingWriter counts the newline terminated lines written through it.
+type lineCountingWriter struct {
+	w     io.Writer
+	lines int
+	// partial is true if the last line written has not been terminated yet.
+	partial bool
+}
+
+func (c *lineCountingWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.lines += bytes.Count(p, []byte{'\n'})
+	c.partial = p[len(p)-1] != '\n'
+	return c.w.Write(p)
+}
+
+// endLine terminates the last line if it has not been.
+func (c *lineCountingWriter) endLine() error {
+	if !c.partial {
+		return nil
+	}
+	_, err := c.Write([]byte{'\n'})
+	return err
+}
+
+// saveManifest writes the manifest to a temporary file and renames it, so it is never left half written.
+func (e *searchExporter) saveManifest() error {
+	// The lock is held until the rename, so a manifest saved earlier never replaces one saved later.
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	data, err := json.MarshalIndent(e.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(e.outDir, searchExportManifestName)
+	tmpPath := fmt.Sprintf("%s.%d.tmp", path, time.Now().UnixNano())
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+var relativeTimeRegex = regexp.MustCompile(`^(\d+)\s*([a-z]+)$`)
+
+var relativeTimeUnits = map[string]time.Duration{
+	"ms": time.Millisecond, "millis": time.Millisecond,
+	"s": time.Second, "sec": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour, "year": 365 * 24 * time.Hour, "years": 365 * 24 * time.Hour,
+}
+
+// isAbsoluteSearchTime returns true if s is an absolute time, as RFC 3339 or milliseconds since the epoch.
+func isAbsoluteSearchTime(s string) bool {
+	s = strings.TrimSpace(s)
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return true
+	}
+	_, err := time.Parse(time.RFC3339Nano, s)
+	return err == nil
+}
+
+// parseSearchTime parses an absolute time, as RFC 3339 or milliseconds since the epoch, or a time relative to now
+// such as "7d". The empty string and "now" are now.
+func parseSearchTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "now" {
+		return now, nil
+	}
+	if millis, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if match := relativeTimeRegex.FindStringSubmatch(strings.ToLower(s)); match != nil {
+		if unit, ok := relativeTimeUnits[match[2]]; ok {
+			n, err := strconv.ParseInt(match[1], 10, 64)
+			if err == nil {
+				return now.Add(-time.Duration(n) * unit), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse time %q, expected RFC 3339, milliseconds since the epoch or a relative time such as '7d'", s)
+}
+
+func formatMillis(millis int64) string {
+	return time.UnixMilli(millis).UTC().Format(time.RFC3339Nano)
+}
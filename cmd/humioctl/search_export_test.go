@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/humio/cli/internal/api"
+)
+
+// fakeStreamingSearch answers searches with an event per second of the searched time range, and fails the searches
+// starting at or after failFrom.
+type fakeStreamingSearch struct {
+	mu       sync.Mutex
+	failFrom int64
+	searched []int64
+}
+
+func (s *fakeStreamingSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/api/v1/repositories/repo/query" {
+		http.NotFound(w, r)
+		return
+	}
+	var query struct {
+		Start int64 `json:"start"`
+		End   int64 `json:"end"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.searched = append(s.searched, query.Start)
+	failed := s.failFrom != 0 && query.Start >= s.failFrom
+	s.mu.Unlock()
+	if failed {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", api.NDJSONContentType)
+	for t := query.Start; t < query.End; t += 1000 {
+		fmt.Fprintf(w, "{\"@timestamp\":%d}\n", t)
+	}
+}
+
+func (s *fakeStreamingSearch) searchedStarts() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64(nil), s.searched...)
+}
+
+func newTestSearchExporter(t *testing.T, server *httptest.Server, dir string, manifest *searchExportManifest) *searchExporter {
+	t.Helper()
+	address, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &searchExporter{
+		queryJobs:  api.NewClient(api.Config{Address: address, Token: "token"}).QueryJobs(),
+		repository: manifest.Repository,
+		query:      manifest.QueryString,
+		outDir:     dir,
+		manifest:   manifest,
+	}
+}
+
+func TestSearchExportResumesInterruptedExport(t *testing.T) {
+	const start, chunk = int64(1_700_000_000_000), int64(60_000)
+	export := searchExportManifest{Repository: "repo", QueryString: "error", Start: start, End: start + 4*chunk, ChunkMillis: chunk}
+	dir := t.TempDir()
+
+	search := &fakeStreamingSearch{failFrom: start + 2*chunk}
+	server := httptest.NewServer(search)
+	defer server.Close()
+
+	manifest, err := loadSearchExportManifest(dir, export, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestSearchExporter(t, server, dir, manifest).run(context.Background(), 1, func(searchExportChunk) {}); err == nil {
+		t.Fatal("expected the export to fail")
+	}
+
+	// The export is continued with the time range of the manifest, as when it was started relative to now.
+	later := export
+	later.Start += 30_000
+	later.End += 30_000
+	if _, err := loadSearchExportManifest(dir, later, false); err == nil {
+		t.Error("expected an error continuing the export with another absolute time range")
+	}
+	manifest, err = loadSearchExportManifest(dir, later, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Start != export.Start || manifest.End != export.End {
+		t.Fatalf("expected the time range of the manifest, got %d to %d", manifest.Start, manifest.End)
+	}
+
+	search.mu.Lock()
+	search.failFrom = 0
+	search.searched = nil
+	search.mu.Unlock()
+	if err := newTestSearchExporter(t, server, dir, manifest).run(context.Background(), 2, func(searchExportChunk) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, searched := range search.searchedStarts() {
+		if searched < start+2*chunk {
+			t.Errorf("expected the chunk starting at %d not to be searched again", searched)
+		}
+	}
+
+	seen := map[int64]int{}
+	for _, c := range manifest.Chunks {
+		if !c.Done || c.Events != int(chunk/1000) {
+			t.Errorf("expected chunk %d to be done with %d events, got %+v", c.Start, chunk/1000, c)
+		}
+		for _, timestamp := range readExportedTimestamps(t, filepath.Join(dir, c.File)) {
+			seen[timestamp]++
+		}
+	}
+	for timestamp := export.Start; timestamp < export.End; timestamp += 1000 {
+		if seen[timestamp] != 1 {
+			t.Errorf("expected the event at %d once, got it %d times", timestamp, seen[timestamp])
+		}
+	}
+	if len(seen) != int((export.End-export.Start)/1000) {
+		t.Errorf("expected %d events, got %d", (export.End-export.Start)/1000, len(seen))
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(leftovers) != 0 {
+		t.Errorf("expected no temporary files, got %q", leftovers)
+	}
+}
+
+func TestSearchExportSavesLatestManifest(t *testing.T) {
+	const start, chunk = int64(1_700_000_000_000), int64(10_000)
+	export := searchExportManifest{Repository: "repo", QueryString: "error", Start: start, End: start + 32*chunk, ChunkMillis: chunk}
+	dir := t.TempDir()
+
+	server := httptest.NewServer(&fakeStreamingSearch{})
+	defer server.Close()
+
+	manifest, err := loadSearchExportManifest(dir, export, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestSearchExporter(t, server, dir, manifest).run(context.Background(), 8, func(searchExportChunk) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := loadSearchExportManifest(dir, export, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range saved.Chunks {
+		if !c.Done {
+			t.Errorf("expected the saved manifest to have chunk %d done", c.Start)
+		}
+	}
+}
+
+func readExportedTimestamps(t *testing.T, path string) []int64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var timestamps []int64
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var event struct {
+			Timestamp int64 `json:"@timestamp"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("could not parse %q: %v", scanner.Text(), err)
+		}
+		timestamps = append(timestamps, event.Timestamp)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return timestamps
+}
+
+func TestLineCountingWriterEndsLastLine(t *testing.T) {
+	var out bytes.Buffer
+	w := &lineCountingWriter{w: &out}
+
+	_, _ = w.Write([]byte("{\"a\":1}\n{\"a\""))
+	_, _ = w.Write([]byte(":2}"))
+	if err := w.endLine(); err != nil {
+		t.Fatal(err)
+	}
+	if w.lines != 2 || out.String() != "{\"a\":1}\n{\"a\":2}\n" {
+		t.Errorf("expected 2 terminated lines, got %d in %q", w.lines, out.String())
+	}
+}
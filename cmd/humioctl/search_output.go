@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/humio/cli/internal/api"
+)
+
+const (
+	searchOutputNDJSON = "ndjson"
+	searchOutputJSON   = "json"
+	searchOutputCSV    = "csv"
+	searchOutputTSV    = "tsv"
+)
+
+// searchOutputPrinter prints search results in a format meant for other
+// tools rather than people. Event lists are printed incrementally, each event
+// once. Aggregates are printed in full every time they are updated.
+type searchOutputPrinter struct {
+	w          io.Writer
+	format     string
+	printedIds map[string]bool
+	// columns is the order of the fields. For event lists it is set by the first events printed, and the fields of
+	// later events are added to the end.
+	columns       []string
+	headerColumns []string
+}
+
+func newSearchOutputPrinter(w io.Writer, format string) (*searchOutputPrinter, error) {
+	switch format {
+	case searchOutputNDJSON, searchOutputJSON, searchOutputCSV, searchOutputTSV:
+	default:
+		return nil, fmt.Errorf("unknown output format %q, expected 'ndjson', 'json', 'csv' or 'tsv'", format)
+	}
+
+	return &searchOutputPrinter{
+		w:          w,
+		format:     format,
+		printedIds: map[string]bool{},
+	}, nil
+}
+
+func (p *searchOutputPrinter) print(result api.QueryResult) {
+	events := result.Events
+
+	if result.Metadata.IsAggregate {
+		p.columns = resultColumns(result.Metadata.FieldOrder, events)
+	} else {
+		sortEventsByTimestamp(events)
+		events = p.newEvents(events)
+		if len(events) == 0 {
+			return
+		}
+		p.columns = addColumns(p.columns, resultColumns(result.Metadata.FieldOrder, events))
+	}
+
+	switch p.format {
+	case searchOutputNDJSON:
+		for _, e := range events {
+			p.w.Write(append(marshalOrderedEvent(e, p.columns), '\n'))
+		}
+	case searchOutputJSON:
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, e := range events {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString("\n  ")
+			buf.Write(marshalOrderedEvent(e, p.columns))
+		}
+		if len(events) > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString("]\n")
+		p.w.Write(buf.Bytes())
+	case searchOutputCSV, searchOutputTSV:
+		p.printRows(events, result.Metadata.IsAggregate)
+	}
+}
+
+// newEvents returns the events that have not been printed before, as live searches return them again.
+func (p *searchOutputPrinter) newEvents(events []map[string]interface{}) []map[string]interface{} {
+	var fresh []map[string]interface{}
+	for _, e := range events {
+		id, hasID := e["@id"].(string)
+		if hasID && p.printedIds[id] {
+			continue
+		}
+		if hasID {
+			p.printedIds[id] = true
+		}
+		fresh = append(fresh, e)
+	}
+	return fresh
+}
+
+// printRows prints events as rows. Aggregates replace the rows printed before, so each update of a live aggregate is
+// printed as a table of its own, with an empty line before it and its own header.
+func (p *searchOutputPrinter) printRows(events []map[string]interface{}, aggregate bool) {
+	w := csv.NewWriter(p.w)
+	if p.format == searchOutputTSV {
+		w.Comma = '\t'
+	}
+
+	if aggregate && p.headerColumns != nil {
+		_, _ = io.WriteString(p.w, "\n")
+	}
+	// The header of events is printed again if later events have new fields.
+	if aggregate || !slices.Equal(p.headerColumns, p.columns) {
+		_ = w.Write(p.columns)
+		p.headerColumns = p.columns
+	}
+
+	for _, e := range events {
+		row := make([]string, len(p.columns))
+		for i, column := range p.columns {
+			row[i] = formatSearchValue(e[column])
+		}
+		_ = w.Write(row)
+	}
+
+	w.Flush()
+}
+
+// resultColumns returns fieldOrder if set, and otherwise the fields of events with @timestamp first and the rest sorted.
+func resultColumns(fieldOrder []string, events []map[string]interface{}) []string {
+	if len(fieldOrder) > 0 {
+		return fieldOrder
+	}
+
+	seen := map[string]bool{}
+	var columns []string
+	for _, e := range events {
+		for k := range e {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+	}
+
+	sort.Slice(columns, func(i, j int) bool {
+		if columns[i] == "@timestamp" || columns[j] == "@timestamp" {
+			return columns[i] == "@timestamp"
+		}
+		return columns[i] < columns[j]
+	})
+	return columns
+}
+
+// addColumns returns columns with the columns in more it does not have added to the end.
+func addColumns(columns, more []string) []string {
+	for _, column := range more {
+		if !slices.Contains(columns, column) {
+			columns = append(slices.Clip(columns), column)
+		}
+	}
+	return columns
+}
+
+// marshalOrderedEvent returns e as a JSON object with the fields in columns first, in that order.
+// Nested values are kept as they are.
+func marshalOrderedEvent(e map[string]interface{}, columns []string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	written := map[string]bool{}
+	writeField := func(k string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(',')
+		}
+		_ = enc.Encode(k)
+		buf.Truncate(buf.Len() - 1)
+		buf.WriteByte(':')
+		if err := enc.Encode(e[k]); err != nil {
+			_ = enc.Encode(fmt.Sprint(e[k]))
+		}
+		buf.Truncate(buf.Len() - 1)
+		written[k] = true
+	}
+
+	for _, k := range columns {
+		if _, ok := e[k]; ok && !written[k] {
+			writeField(k)
+		}
+	}
+
+	rest := make([]string, 0, len(e))
+	for k := range e {
+		if !written[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		writeField(k)
+	}
+
+	return append(append([]byte{'{'}, buf.Bytes()...), '}')
+}
+
+// formatSearchValue formats a value for a CSV cell. Nested objects and arrays are written as JSON.
+func formatSearchValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/humio/cli/internal/api"
+)
+
+func TestResultColumns(t *testing.T) {
+	events := []map[string]interface{}{
+		{"b": 1, "@timestamp": 2, "a": 3},
+		{"c": 4, "a": 5},
+	}
+
+	if got := resultColumns([]string{"x", "y"}, events); !reflect.DeepEqual(got, []string{"x", "y"}) {
+		t.Errorf("expected the field order of the result, got %q", got)
+	}
+
+	want := []string{"@timestamp", "a", "b", "c"}
+	if got := resultColumns(nil, events); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestMarshalOrderedEvent(t *testing.T) {
+	e := map[string]interface{}{
+		"z":      "last in columns",
+		"a":      1.5,
+		"nested": map[string]interface{}{"k": []interface{}{"v", true}},
+		"html":   "<b>&</b>",
+		"b":      nil,
+	}
+
+	got := string(marshalOrderedEvent(e, []string{"z", "missing", "a"}))
+	want := `{"z":"last in columns","a":1.5,"b":null,"html":"<b>&</b>","nested":{"k":["v",true]}}`
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestFormatSearchValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, ""},
+		{"text", "text"},
+		{42.0, "42"},
+		{1e21, "1000000000000000000000"},
+		{0.25, "0.25"},
+		{true, "true"},
+		{map[string]interface{}{"a": 1.0}, `{"a":1}`},
+		{[]interface{}{"a", 2.0}, `["a",2]`},
+	}
+
+	for _, tt := range tests {
+		if got := formatSearchValue(tt.value); got != tt.want {
+			t.Errorf("formatSearchValue(%#v): expected %q, got %q", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestSearchOutputPrinterAddsColumnsOfLaterEvents(t *testing.T) {
+	var out bytes.Buffer
+	p, err := newSearchOutputPrinter(&out, searchOutputCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.print(api.QueryResult{Events: []map[string]interface{}{{"@id": "1", "@timestamp": 1.0, "a": "x"}}})
+	p.print(api.QueryResult{Events: []map[string]interface{}{
+		{"@id": "1", "@timestamp": 1.0, "a": "x"},
+		{"@id": "2", "@timestamp": 2.0, "a": "y", "b": "z"},
+	}})
+
+	want := "@timestamp,@id,a\n1,1,x\n@timestamp,@id,a,b\n2,2,y,z\n"
+	if out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+}
+
+func TestSearchOutputPrinterSeparatesAggregateUpdates(t *testing.T) {
+	for _, format := range []string{searchOutputCSV, searchOutputTSV} {
+		t.Run(format, func(t *testing.T) {
+			var out bytes.Buffer
+			p, err := newSearchOutputPrinter(&out, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			metadata := api.QueryResultMetadata{IsAggregate: true, FieldOrder: []string{"host", "_count"}}
+			p.print(api.QueryResult{Metadata: metadata, Events: []map[string]interface{}{{"host": "a", "_count": 1.0}}})
+			p.print(api.QueryResult{Metadata: metadata, Events: []map[string]interface{}{{"host": "a", "_count": 2.0}, {"host": "b", "_count": 1.0}}})
+
+			want := "host,_count\na,1\n\nhost,_count\na,2\nb,1\n"
+			if format == searchOutputTSV {
+				want = strings.ReplaceAll(want, ",", "\t")
+			}
+			if out.String() != want {
+				t.Errorf("expected %q, got %q", want, out.String())
+			}
+		})
+	}
+}
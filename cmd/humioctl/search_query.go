@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// searchQueryString returns the query given either as the only element of args, with -q, which reads the file
+// named after an @, or with --query-file. Queries read from files are rendered by renderQueryFile.
+func searchQueryString(args []string, query, queryFile string, varPairs []string) (string, error) {
+	given := 0
+	for _, ok := range []bool{len(args) > 0, query != "", queryFile != ""} {
+		if ok {
+			given++
+		}
+	}
+	if given != 1 {
+		return "", errors.New("the query must be given once, either as an argument, with -q or with --query-file")
+	}
+
+	if strings.HasPrefix(query, "@") {
+		query, queryFile = "", query[1:]
+	}
+
+	vars := map[string]string{}
+	for _, pair := range varPairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return "", fmt.Errorf("invalid variable %q, expected name=value", pair)
+		}
+		vars[name] = value
+	}
+
+	switch {
+	case queryFile != "":
+		return renderQueryFile(queryFile, vars)
+	case len(vars) > 0:
+		return "", errors.New("variables can only be used with a query read from a file")
+	case query != "":
+		return query, nil
+	default:
+		return args[0], nil
+	}
+}
+
+var includeRegex = regexp.MustCompile(`^\s*#include\s+"?([^"\s]+)"?\s*$`)
+
+// renderQueryFile returns the query in the file at path. Lines such as '#include "filters.lql"' are replaced by
+// the file they name, relative to the including file. The query is then rendered as a Go template with vars,
+// e.g. {{.host}}, and the function quote for string literals, e.g. {{quote .user}}.
+func renderQueryFile(path string, vars map[string]string) (string, error) {
+	text, err := includeQueryFiles(path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"quote": quoteQueryString}).
+		Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, vars); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(rendered.String()), nil
+}
+
+// includeQueryFiles returns the contents of the file at path with its includes replaced. including holds the
+// files currently being included, to detect cycles.
+func includeQueryFiles(path string, including []string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if slices.Contains(including, absPath) {
+		return "", fmt.Errorf("%s includes itself", path)
+	}
+
+	// #nosec G304
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		match := includeRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		includePath := match[1]
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+		text, err := includeQueryFiles(includePath, append(including, absPath))
+		if err != nil {
+			return "", fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		lines[i] = strings.TrimRight(text, "\n")
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// quoteQueryString returns s as a string literal of the query language.
+func quoteQueryString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// parseQueryArguments returns the values of the parameters of a query, such as ?host, from name=value pairs.
+// The pairs in the file at path, one per line, are read first, so pairs given as arguments take precedence.
+func parseQueryArguments(pairs []string, path string) (map[string]string, error) {
+	arguments := map[string]string{}
+
+	if path != "" {
+		// #nosec G304
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for lineNumber := 1; scanner.Scan(); lineNumber++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			name, value, err := parseQueryArgument(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+			}
+			arguments[name] = strings.TrimSpace(value)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pair := range pairs {
+		name, value, err := parseQueryArgument(pair)
+		if err != nil {
+			return nil, err
+		}
+		arguments[name] = value
+	}
+
+	if len(arguments) == 0 {
+		return nil, nil
+	}
+	return arguments, nil
+}
+
+func parseQueryArgument(pair string) (string, string, error) {
+	name, value, ok := strings.Cut(pair, "=")
+	name = strings.TrimPrefix(strings.TrimSpace(name), "?")
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid argument %q, expected name=value", pair)
+	}
+	return name, value, nil
+}
+
+// timezoneOffsetMinutes returns the offset from UTC of the time zone named tz, such as "Europe/Copenhagen", at the
+// start of the query window. The warning is set if the offset changes within the window, e.g. because daylight
+// saving time starts or ends, or if the window could not be parsed, in which case the offset at now is returned.
+func timezoneOffsetMinutes(tz string, start, end string, now time.Time) (int, string, error) {
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return 0, "", err
+	}
+
+	startTime, startErr := parseSearchTime(start, now)
+	endTime, endErr := parseSearchTime(end, now)
+	if startErr != nil || endErr != nil {
+		_, offset := now.In(location).Zone()
+		return offset / 60, fmt.Sprintf("Could not tell the start and end of the search, the current offset of %s is used.", tz), nil
+	}
+
+	_, startOffset := startTime.In(location).Zone()
+	_, endOffset := endTime.In(location).Zone()
+	if startOffset != endOffset {
+		return startOffset / 60, fmt.Sprintf("The offset of %s changes during the search, the offset at the start is used.", tz), nil
+	}
+	return startOffset / 60, "", nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// writeQueryTestFiles writes files, named by their path relative to a temporary directory, and returns the directory.
+func writeQueryTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestParseQueryArguments(t *testing.T) {
+	dir := writeQueryTestFiles(t, map[string]string{
+		"args":    "# the defaults\n\n?host = web-1 \nuser=alice\n  # indented comment\nregion=eu=west\n",
+		"invalid": "host=web-1\n# comment\n=missing name\n",
+	})
+
+	tests := []struct {
+		name    string
+		pairs   []string
+		path    string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "none", want: nil},
+		{name: "pairs", pairs: []string{"?host=web-2", "user= bob"}, want: map[string]string{"host": "web-2", "user": " bob"}},
+		{
+			name: "file",
+			path: "args",
+			want: map[string]string{"host": "web-1", "user": "alice", "region": "eu=west"},
+		},
+		{
+			name:  "pairs take precedence over the file",
+			pairs: []string{"user=bob"},
+			path:  "args",
+			want:  map[string]string{"host": "web-1", "user": "bob", "region": "eu=west"},
+		},
+		{name: "invalid pair", pairs: []string{"host"}, wantErr: `invalid argument "host"`},
+		{name: "invalid line", path: "invalid", wantErr: "invalid:3: invalid argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path
+			if path != "" {
+				path = filepath.Join(dir, path)
+			}
+			got, err := parseQueryArguments(tt.pairs, path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTimezoneOffsetMinutes(t *testing.T) {
+	// Daylight saving time starts in Copenhagen at 2024-03-31T01:00:00Z.
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		start, end  string
+		want        int
+		wantWarning string
+	}{
+		{name: "winter", start: "2024-01-01T00:00:00Z", end: "2024-01-02T00:00:00Z", want: 60},
+		{name: "summer relative to now", start: "1d", end: "", want: 120},
+		{name: "change", start: "2024-03-30T00:00:00Z", end: "2024-04-01T00:00:00Z", want: 60, wantWarning: "changes during the search"},
+		{name: "unparsed start", start: "yesterday", end: "", want: 120, wantWarning: "the current offset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warning, err := timezoneOffsetMinutes("Europe/Copenhagen", tt.start, tt.end, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected an offset of %d, got %d", tt.want, got)
+			}
+			if (tt.wantWarning == "") != (warning == "") || !strings.Contains(warning, tt.wantWarning) {
+				t.Errorf("expected a warning containing %q, got %q", tt.wantWarning, warning)
+			}
+		})
+	}
+
+	if _, _, err := timezoneOffsetMinutes("Nowhere/Special", "1d", "", now); err == nil {
+		t.Error("expected an error for an unknown time zone")
+	}
+}
+
+func TestSearchQueryString(t *testing.T) {
+	dir := writeQueryTestFiles(t, map[string]string{"query.lql": "user={{.user}}\n"})
+	file := filepath.Join(dir, "query.lql")
+
+	tests := []struct {
+		name      string
+		args      []string
+		query     string
+		queryFile string
+		vars      []string
+		want      string
+		wantErr   string
+	}{
+		{name: "argument", args: []string{"error"}, want: "error"},
+		{name: "flag", query: "error", want: "error"},
+		{name: "file with @", query: "@" + file, vars: []string{"user=alice"}, want: "user=alice"},
+		{name: "query file", queryFile: file, vars: []string{"user=alice"}, want: "user=alice"},
+		{name: "none", wantErr: "must be given once"},
+		{name: "argument and flag", args: []string{"error"}, query: "warning", wantErr: "must be given once"},
+		{name: "argument and file", args: []string{"error"}, queryFile: file, wantErr: "must be given once"},
+		{name: "flag and file", query: "@" + file, queryFile: file, wantErr: "must be given once"},
+		{name: "variables without file", query: "error", vars: []string{"user=alice"}, wantErr: "only be used with a query read from a file"},
+		{name: "invalid variable", queryFile: file, vars: []string{"user"}, wantErr: `invalid variable "user"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := searchQueryString(tt.args, tt.query, tt.queryFile, tt.vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRenderQueryFile(t *testing.T) {
+	dir := writeQueryTestFiles(t, map[string]string{
+		"main.lql":            "#include \"lib/filters.lql\"\n| user={{quote .user}}\n",
+		"lib/filters.lql":     "#include common.lql\n| status>=500",
+		"lib/common.lql":      "#kind=access\n",
+		"self.lql":            "#include \"self.lql\"\n",
+		"cycle/a.lql":         "#include \"b.lql\"\n",
+		"cycle/b.lql":         "x\n#include \"../cycle/a.lql\"\n",
+		"missing-include.lql": "#include \"nowhere.lql\"\n",
+		"missing-var.lql":     "host={{.host}}\n",
+		"quote.lql":           "{{quote .value}}",
+		"twice.lql":           "#include \"lib/common.lql\"\n#include \"lib/common.lql\"\n",
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		vars    map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "includes relative to the including file",
+			path: "main.lql",
+			vars: map[string]string{"user": "alice"},
+			want: "#kind=access\n| status>=500\n| user=\"alice\"",
+		},
+		{name: "same file twice", path: "twice.lql", want: "#kind=access\n#kind=access"},
+		{name: "include of itself", path: "self.lql", wantErr: "self.lql:1: " + filepath.Join(dir, "self.lql") + " includes itself"},
+		{name: "cycle", path: "cycle/a.lql", wantErr: "includes itself"},
+		{name: "missing include", path: "missing-include.lql", wantErr: "missing-include.lql:1: open"},
+		{name: "missing variable", path: "missing-var.lql", wantErr: `map has no entry for key "host"`},
+		{name: "quote", path: "quote.lql", vars: map[string]string{"value": `a "b" \c`}, want: `"a \"b\" \\c"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderQueryFile(filepath.Join(dir, tt.path), tt.vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
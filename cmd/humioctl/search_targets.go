@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/humio/cli/internal/api"
+)
+
+const (
+	aggregatesPerSource = "per-source"
+	aggregatesConcat    = "concat"
+)
+
+// searchTarget is a repository to search, in the cluster of a saved profile.
+type searchTarget struct {
+	// name is the target as given, e.g. "profile:repo", and is the value of the #repo field of its events.
+	name       string
+	repository string
+	queryJobs  *api.QueryJobs
+	poller     *queryJobPoller
+	result     api.QueryResult
+	polled     bool
+}
+
+// isMultiTargetSearch returns true if repository is a list of repositories, or names the profile of a repository.
+func isMultiTargetSearch(repository string) bool {
+	return strings.ContainsAny(repository, ",:")
+}
+
+// parseSearchTargets parses a comma separated list of repositories given as '<profile>:<repo>' or '<repo>' for
+// the current profile.
+func parseSearchTargets(spec string) ([]*searchTarget, error) {
+	var targets []*searchTarget
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s is given more than once", name)
+		}
+		seen[name] = true
+
+		profile, repository := splitProfileRepo(name)
+		if repository == "" {
+			return nil, fmt.Errorf("%s does not name a repository", name)
+		}
+		client, err := newApiClientForProfileE(profile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		targets = append(targets, &searchTarget{
+			name:       name,
+			repository: repository,
+			queryJobs:  client.QueryJobs(),
+		})
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no repositories given")
+	}
+	return targets, nil
+}
+
+// multiQueryPoller polls a query job for each target and merges their results, adding a #repo field with the
+// name of the target to each event.
+type multiQueryPoller struct {
+	targets []*searchTarget
+	live    bool
+}
+
+// startMultiQuery starts the query on all targets at the same time.
+func startMultiQuery(targets []*searchTarget, query api.Query) (*multiQueryPoller, error) {
+	m := &multiQueryPoller{targets: targets, live: query.Live}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(targets))
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := t.queryJobs.Create(t.repository, query)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", t.name, err)
+				return
+			}
+			t.poller = &queryJobPoller{queryJobs: t.queryJobs, repository: t.repository, id: id}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			m.delete()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// delete deletes the query jobs that were started.
+func (m *multiQueryPoller) delete() {
+	for _, t := range m.targets {
+		if t.poller != nil {
+			// Humio will eventually delete the query when we stop polling and we can't do much about errors here.
+			_ = t.queryJobs.Delete(t.repository, t.poller.id)
+		}
+	}
+}
+
+// WaitAndPollContext polls all targets whose query is not done yet, or all targets of a live query.
+func (m *multiQueryPoller) WaitAndPollContext(ctx context.Context) (api.QueryResult, error) {
+	var wg sync.WaitGroup
+	errs := make([]error, len(m.targets))
+	for i, t := range m.targets {
+		if t.polled && t.result.Done && !m.live {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := t.poller.WaitAndPollContext(ctx)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", t.name, err)
+				return
+			}
+			t.result = result
+			t.polled = true
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return api.QueryResult{}, err
+		}
+	}
+	return m.merge(), nil
+}
+
+// merge combines the latest results of the targets into one, which is done when all of them are.
+func (m *multiQueryPoller) merge() api.QueryResult {
+	merged := api.QueryResult{Done: true}
+	metadata := &merged.Metadata
+
+	for _, t := range m.targets {
+		r := t.result
+		merged.Done = merged.Done && r.Done
+		merged.Cancelled = merged.Cancelled || r.Cancelled
+
+		metadata.IsAggregate = metadata.IsAggregate || r.Metadata.IsAggregate
+		metadata.EventCount += r.Metadata.EventCount
+		metadata.ProcessedBytes += r.Metadata.ProcessedBytes
+		metadata.ProcessedEvents += r.Metadata.ProcessedEvents
+		metadata.TotalWork += r.Metadata.TotalWork
+		metadata.WorkDone += r.Metadata.WorkDone
+		metadata.TimeMillis = max(metadata.TimeMillis, r.Metadata.TimeMillis)
+		metadata.QueryEnd = max(metadata.QueryEnd, r.Metadata.QueryEnd)
+		if metadata.QueryStart == 0 || (r.Metadata.QueryStart != 0 && r.Metadata.QueryStart < metadata.QueryStart) {
+			metadata.QueryStart = r.Metadata.QueryStart
+		}
+
+		for _, field := range r.Metadata.FieldOrder {
+			if !slices.Contains(metadata.FieldOrder, field) {
+				metadata.FieldOrder = append(metadata.FieldOrder, field)
+			}
+		}
+
+		// The events are copied, as the result of a target is kept until it is polled again.
+		for _, e := range r.Events {
+			event := make(map[string]interface{}, len(e)+1)
+			for k, v := range e {
+				event[k] = v
+			}
+			event["#repo"] = t.name
+			merged.Events = append(merged.Events, event)
+		}
+	}
+
+	if len(metadata.FieldOrder) > 0 {
+		metadata.FieldOrder = append([]string{"#repo"}, slices.DeleteFunc(metadata.FieldOrder, func(field string) bool {
+			return field == "#repo"
+		})...)
+	}
+
+	return merged
+}
+
+// perSourceAggregatePrinter prints the aggregate result of each target of a search separately.
+type perSourceAggregatePrinter struct {
+	w        io.Writer
+	names    []string
+	printers map[string]*aggregatePrinter
+}
+
+func newPerSourceAggregatePrinter(w io.Writer, noWrap bool, targets []*searchTarget) *perSourceAggregatePrinter {
+	p := &perSourceAggregatePrinter{w: w, printers: map[string]*aggregatePrinter{}}
+	for _, t := range targets {
+		p.names = append(p.names, t.name)
+		p.printers[t.name] = newAggregatePrinter(w, noWrap)
+	}
+	return p
+}
+
+func (p *perSourceAggregatePrinter) print(result api.QueryResult) {
+	fieldOrder := slices.DeleteFunc(slices.Clone(result.Metadata.FieldOrder), func(field string) bool {
+		return field == "#repo"
+	})
+
+	for _, name := range p.names {
+		source := api.QueryResult{Done: result.Done, Metadata: result.Metadata}
+		source.Metadata.FieldOrder = fieldOrder
+		for _, e := range result.Events {
+			if e["#repo"] != name {
+				continue
+			}
+			event := make(map[string]interface{}, len(e)-1)
+			for k, v := range e {
+				if k != "#repo" {
+					event[k] = v
+				}
+			}
+			source.Events = append(source.Events, event)
+		}
+
+		fmt.Fprintf(p.w, "%s:\n", name)
+		p.printers[name].print(source)
+	}
+}
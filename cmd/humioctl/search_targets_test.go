@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/humio/cli/internal/api"
+)
+
+func TestMultiQueryPollerMerge(t *testing.T) {
+	first := &searchTarget{name: "logs", result: api.QueryResult{
+		Done:   true,
+		Events: []map[string]interface{}{{"@id": "1", "user": "alice"}},
+		Metadata: api.QueryResultMetadata{
+			FieldOrder: []string{"@id", "user"},
+			EventCount: 1,
+			QueryStart: 200,
+			QueryEnd:   300,
+		},
+	}}
+	second := &searchTarget{name: "eu:logs", result: api.QueryResult{
+		Events: []map[string]interface{}{{"@id": "2", "host": "web-1"}},
+		Metadata: api.QueryResultMetadata{
+			FieldOrder: []string{"host", "#repo", "@id"},
+			EventCount: 2,
+			QueryStart: 100,
+			QueryEnd:   250,
+		},
+	}}
+	m := &multiQueryPoller{targets: []*searchTarget{first, second}}
+
+	merged := m.merge()
+	if merged.Done || merged.Cancelled {
+		t.Errorf("expected the result not to be done or cancelled while a target is running, got %+v", merged)
+	}
+	if want := []string{"#repo", "@id", "user", "host"}; !reflect.DeepEqual(merged.Metadata.FieldOrder, want) {
+		t.Errorf("expected the field order %q, got %q", want, merged.Metadata.FieldOrder)
+	}
+	if merged.Metadata.EventCount != 3 || merged.Metadata.QueryStart != 100 || merged.Metadata.QueryEnd != 300 {
+		t.Errorf("expected the metadata to be combined, got %+v", merged.Metadata)
+	}
+	want := []map[string]interface{}{
+		{"#repo": "logs", "@id": "1", "user": "alice"},
+		{"#repo": "eu:logs", "@id": "2", "host": "web-1"},
+	}
+	if !reflect.DeepEqual(merged.Events, want) {
+		t.Errorf("expected %v, got %v", want, merged.Events)
+	}
+	if _, ok := first.result.Events[0]["#repo"]; ok {
+		t.Error("expected the events of the target to be left unchanged")
+	}
+
+	second.result.Done = true
+	second.result.Cancelled = true
+	merged = m.merge()
+	if !merged.Done || !merged.Cancelled {
+		t.Errorf("expected the result to be done and cancelled, got done %t and cancelled %t", merged.Done, merged.Cancelled)
+	}
+}
+
+func TestPerSourceAggregatePrinter(t *testing.T) {
+	var out bytes.Buffer
+	p := newPerSourceAggregatePrinter(&out, false, []*searchTarget{{name: "logs"}, {name: "eu:logs"}})
+
+	p.print(api.QueryResult{
+		Done: true,
+		Events: []map[string]interface{}{
+			{"#repo": "eu:logs", "count": "2"},
+			{"#repo": "logs", "count": "1"},
+		},
+		Metadata: api.QueryResultMetadata{IsAggregate: true, FieldOrder: []string{"#repo", "count"}},
+	})
+
+	if want := "logs:\n1\neu:logs:\n2\n"; out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+}
@@ -245,11 +245,19 @@ func (c *Client) headers() map[string]string {
 const JSONContentType string = "application/json"
 const ZIPContentType string = "application/zip"
 
+// NDJSONContentType is "application/x-ndjson", for newline delimited JSON
+const NDJSONContentType string = "application/x-ndjson"
+
 func (c *Client) HTTPRequest(httpMethod string, path string, body io.Reader) (*http.Response, error) {
 	return c.HTTPRequestContext(context.Background(), httpMethod, path, body, JSONContentType)
 }
 
 func (c *Client) HTTPRequestContext(ctx context.Context, httpMethod string, path string, body io.Reader, contentType string) (*http.Response, error) {
+	return c.HTTPRequestContextWithHeaders(ctx, httpMethod, path, body, map[string]string{"Content-Type": contentType})
+}
+
+// HTTPRequestContextWithHeaders is like HTTPRequestContext, but sets the given headers, e.g. Content-Type and Content-Encoding, on the request.
+func (c *Client) HTTPRequestContextWithHeaders(ctx context.Context, httpMethod string, path string, body io.Reader, extraHeaders map[string]string) (*http.Response, error) {
 	if body == nil {
 		body = bytes.NewReader(nil)
 	}
@@ -265,7 +273,9 @@ func (c *Client) HTTPRequestContext(ctx context.Context, httpMethod string, path
 	}
 
 	headers := c.headers()
-	headers["Content-Type"] = contentType
+	for key, val := range extraHeaders {
+		headers[key] = val
+	}
 
 	var client = c.newHTTPClientWithHeaders(headers)
 	return client.Do(req)
@@ -122,6 +122,47 @@ func (q *QueryJobs) PollContext(ctx context.Context, repository string, id strin
 	return result, err
 }
 
+// streamQuery is the body of a streaming query. The time range is absolute, in milliseconds since the epoch.
+type streamQuery struct {
+	QueryString string `json:"queryString"`
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+}
+
+// StreamContext runs a query that is not live over the events from start to end, in milliseconds since the epoch,
+// and returns the response body, which holds the events of the result as NDJSON. Unlike the result of a query job,
+// the number of events is not limited by a result buffer, and they are returned as they are found. The caller must
+// close the body.
+func (q *QueryJobs) StreamContext(ctx context.Context, repository, queryString string, start, end int64) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(streamQuery{QueryString: queryString, Start: start, End: end}); err != nil {
+		return nil, err
+	}
+
+	resp, err := q.client.HTTPRequestContextWithHeaders(ctx, http.MethodPost, "api/v1/repositories/"+url.QueryEscape(repository)+"/query", &buf, map[string]string{
+		"Content-Type": JSONContentType,
+		"Accept":       NDJSONContentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusBadRequest:
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, QueryError{string(body)}
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not run query, got status code %d", resp.StatusCode)
+	}
+}
+
 func (q *QueryJobs) Delete(repository string, id string) error {
 	_, err := q.client.HTTPRequest(http.MethodDelete, "api/v1/repositories/"+url.QueryEscape(repository)+"/queryjobs/"+id, nil)
 	return err
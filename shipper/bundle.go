@@ -0,0 +1,115 @@
+package shipper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BundleWriter writes the requests a LogShipper would send to a file instead,
+// so they can be sent later from another host. The requests are written as
+// records in the format of a dead-letter file, gzip compressed if the path
+// ends in .gz.
+type BundleWriter struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	gz   *gzip.Writer
+	w    io.Writer
+}
+
+// CreateBundle creates the file at path, truncating it if it exists.
+func CreateBundle(path string) (*BundleWriter, error) {
+	// #nosec G304
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not create bundle: %w", err)
+	}
+
+	b := &BundleWriter{path: path, file: f, w: f}
+	if strings.HasSuffix(path, ".gz") {
+		b.gz = gzip.NewWriter(f)
+		b.w = b.gz
+	}
+	return b, nil
+}
+
+func (b *BundleWriter) Path() string {
+	return b.path
+}
+
+func (b *BundleWriter) Write(record DeadLetterRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := b.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write to bundle: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the bundle and closes the file. The bundle is not complete until it is closed.
+func (b *BundleWriter) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.gz != nil {
+		if err := b.gz.Close(); err != nil {
+			_ = b.file.Close()
+			return fmt.Errorf("could not write to bundle: %w", err)
+		}
+	}
+	if err := b.file.Sync(); err != nil {
+		_ = b.file.Close()
+		return err
+	}
+	return b.file.Close()
+}
+
+// ReadBundle calls handle for each record in a bundle, which may be gzip compressed, stopping at the first error.
+func ReadBundle(r io.Reader, handle func(record DeadLetterRecord) error) error {
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(2)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("could not read bundle: %w", err)
+		}
+		defer gz.Close()
+		return ReadDeadLetterRecords(gz, handle)
+	}
+
+	return ReadDeadLetterRecords(reader, handle)
+}
+
+func (s *LogShipper) writeBundle(encode func(w io.Writer) error, events int) error {
+	var body bytes.Buffer
+	if err := encode(&body); err != nil {
+		return err
+	}
+
+	s.metrics.bytesUncompressed.Add(uint64(body.Len()))
+
+	return s.Bundle.Write(DeadLetterRecord{
+		Time:   time.Now(),
+		URL:    s.URL,
+		Events: events,
+		Body:   body.String(),
+	})
+}
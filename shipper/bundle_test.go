@@ -0,0 +1,103 @@
+package shipper
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogShipperWritesBundle(t *testing.T) {
+	for _, name := range []string{"bundle.ndjson", "bundle.ndjson.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			bundle, err := CreateBundle(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ingest, client := newFakeIngest(t)
+			s := newTestShipper(client, "api/v1/ingest/humio-unstructured", func(s *LogShipper) {
+				s.Bundle = bundle
+				s.BatchSizeLines = 2
+			})
+			for _, line := range []string{"one", "two", "three"} {
+				s.HandleLine(line)
+			}
+			s.Finish()
+			if err := bundle.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := len(ingest.received()); n != 0 {
+				t.Fatalf("expected nothing to be sent, got %d requests", n)
+			}
+			if stats := s.Stats(); stats.EventsSent != 3 || stats.BatchesSent != 2 {
+				t.Errorf("expected the bundled events to be counted as sent, got %+v", stats)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gzipped := len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b; gzipped != strings.HasSuffix(name, ".gz") {
+				t.Errorf("expected gzip compression only for .gz, got compressed %t", gzipped)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var records []DeadLetterRecord
+			err = ReadBundle(f, func(record DeadLetterRecord) error {
+				records = append(records, record)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var messages []string
+			for _, record := range records {
+				if record.URL != s.URL || record.Error != "" {
+					t.Errorf("expected a record for %s without an error, got %+v", s.URL, record)
+				}
+				var lists []eventList
+				if err := json.Unmarshal([]byte(record.Body), &lists); err != nil {
+					t.Fatal(err)
+				}
+				for _, l := range lists {
+					messages = append(messages, l.Messages...)
+				}
+			}
+			if len(records) != 2 || strings.Join(messages, ",") != "one,two,three" {
+				t.Errorf("expected two records with all lines, got %+v", records)
+			}
+
+			uploaded, uploadClient := newFakeIngest(t)
+			uploader := &LogShipper{APIClient: uploadClient, MaxAttemptsPerBatch: 1}
+			for _, record := range records {
+				if err := uploader.Resend(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			requests := uploaded.received()
+			if len(requests) != 2 || string(requests[1].body) != records[1].Body {
+				t.Errorf("expected the records to be sent as they were, got %+v", requests)
+			}
+		})
+	}
+}
+
+func TestReadBundleEmpty(t *testing.T) {
+	called := false
+	err := ReadBundle(strings.NewReader(""), func(DeadLetterRecord) error {
+		called = true
+		return nil
+	})
+	if err != nil || called {
+		t.Errorf("expected no records and no error, got %t, %v", called, err)
+	}
+}
@@ -0,0 +1,95 @@
+package shipper
+
+import "sync"
+
+// checkpoints tracks which events handed to a LogShipper are settled: sent,
+// or written to the spool, dead-letter file or bundle. Events are grouped in
+// epochs, each ended by a call to Checkpoint, and the callback of an epoch
+// runs once it and all earlier epochs are settled.
+//
+// Dropped events settle too, so a failed batch does not hold back the epochs
+// after it. They are counted in Stats.EventsDropped and logged instead.
+type checkpoints struct {
+	mu     sync.Mutex
+	nextID uint64
+	// epochs holds the epochs not yet settled, oldest first. The last one is open and receives new events.
+	epochs []*checkpointEpoch
+}
+
+type checkpointEpoch struct {
+	id      uint64
+	handled int
+	settled int
+	done    func()
+}
+
+// handle adds an event to the open epoch and returns the id of the epoch.
+func (c *checkpoints) handle() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	epoch := c.openLocked()
+	epoch.handled++
+	return epoch.id
+}
+
+// checkpoint ends the open epoch, calling done once it is settled.
+func (c *checkpoints) checkpoint(done func()) {
+	c.mu.Lock()
+	epoch := c.openLocked()
+	epoch.done = done
+	c.epochs = append(c.epochs, &checkpointEpoch{id: c.nextID})
+	c.nextID++
+	ready := c.settledLocked()
+	c.mu.Unlock()
+
+	for _, f := range ready {
+		f()
+	}
+}
+
+// settle marks the given number of events of each epoch as settled.
+func (c *checkpoints) settle(counts map[uint64]int) {
+	c.mu.Lock()
+	for id, n := range counts {
+		if id == 0 || len(c.epochs) == 0 || id < c.epochs[0].id {
+			continue
+		}
+		i := int(id - c.epochs[0].id)
+		if i >= len(c.epochs) {
+			continue
+		}
+		c.epochs[i].settled += n
+	}
+	ready := c.settledLocked()
+	c.mu.Unlock()
+
+	for _, f := range ready {
+		f()
+	}
+}
+
+func (c *checkpoints) openLocked() *checkpointEpoch {
+	if len(c.epochs) == 0 {
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		c.epochs = append(c.epochs, &checkpointEpoch{id: c.nextID})
+		c.nextID++
+	}
+	return c.epochs[len(c.epochs)-1]
+}
+
+// settledLocked removes the ended epochs that are settled, oldest first, and returns their callbacks.
+func (c *checkpoints) settledLocked() []func() {
+	var ready []func()
+	for len(c.epochs) > 1 {
+		epoch := c.epochs[0]
+		if epoch.settled < epoch.handled {
+			break
+		}
+		ready = append(ready, epoch.done)
+		c.epochs = c.epochs[1:]
+	}
+	return ready
+}
@@ -0,0 +1,58 @@
+package shipper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointsCallBackInOrderOnceSettled(t *testing.T) {
+	var c checkpoints
+	var called []string
+
+	first := c.handle()
+	second := c.handle()
+	c.checkpoint(func() { called = append(called, "first") })
+	third := c.handle()
+	c.checkpoint(func() { called = append(called, "second") })
+
+	c.settle(map[uint64]int{third: 1})
+	if len(called) != 0 {
+		t.Fatalf("expected no callbacks before the first checkpoint is settled, got %q", called)
+	}
+
+	c.settle(map[uint64]int{first: 1})
+	if first != second || len(called) != 0 {
+		t.Fatalf("expected no callbacks while an event of the first checkpoint is unsettled, got %q", called)
+	}
+
+	c.settle(map[uint64]int{second: 1})
+	if !reflect.DeepEqual(called, []string{"first", "second"}) {
+		t.Errorf("expected both callbacks in order, got %q", called)
+	}
+
+	c.checkpoint(func() { called = append(called, "empty") })
+	if len(called) != 3 {
+		t.Errorf("expected a checkpoint without events to be called back at once, got %q", called)
+	}
+}
+
+func TestCheckpointsSettleAfterDroppedEvents(t *testing.T) {
+	var c checkpoints
+	var called []string
+
+	dropped := c.handle()
+	c.checkpoint(func() { called = append(called, "dropped") })
+	sent := c.handle()
+	c.checkpoint(func() { called = append(called, "sent") })
+
+	// Events of a failed batch are settled like the rest, so the checkpoints after them are not held back.
+	c.settle(map[uint64]int{dropped: 1})
+	c.settle(map[uint64]int{sent: 1})
+
+	if !reflect.DeepEqual(called, []string{"dropped", "sent"}) {
+		t.Errorf("expected both callbacks in order, got %q", called)
+	}
+	if len(c.epochs) != 1 {
+		t.Errorf("expected only the open epoch to be left, got %d epochs", len(c.epochs))
+	}
+}
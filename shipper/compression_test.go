@@ -0,0 +1,86 @@
+package shipper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestParseCompression(t *testing.T) {
+	for _, name := range []string{"none", "gzip", "zstd"} {
+		c, err := ParseCompression(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.String() != name {
+			t.Errorf("expected %q, got %q", name, c)
+		}
+	}
+	if c, err := ParseCompression(""); err != nil || c != CompressionNone {
+		t.Errorf("expected no compression, got %v, %v", c, err)
+	}
+	if _, err := ParseCompression("brotli"); err == nil {
+		t.Error("expected an error for an unknown compression")
+	}
+}
+
+func TestLogShipperCompressesRequests(t *testing.T) {
+	tests := []struct {
+		compression Compression
+		encoding    string
+		decompress  func(r io.Reader) (io.Reader, error)
+	}{
+		{CompressionNone, "", func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{CompressionGzip, "gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{CompressionZstd, "zstd", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.compression.String(), func(t *testing.T) {
+			ingest, client := newFakeIngest(t)
+			s := newTestShipper(client, "api/v1/ingest/humio-unstructured", func(s *LogShipper) {
+				s.Compression = tt.compression
+				s.BatchSizeLines = 100
+			})
+			line := strings.Repeat("GET /index.html 200 ", 20)
+			for range 100 {
+				s.HandleLine(line)
+			}
+			s.Finish()
+
+			requests := ingest.received()
+			if len(requests) != 1 {
+				t.Fatalf("expected one request, got %d", len(requests))
+			}
+			if got := requests[0].header.Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("expected Content-Encoding %q, got %q", tt.encoding, got)
+			}
+
+			r, err := tt.decompress(bytes.NewReader(requests[0].body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lists []eventList
+			if err := json.NewDecoder(r).Decode(&lists); err != nil {
+				t.Fatal(err)
+			}
+			if len(lists) != 1 || len(lists[0].Messages) != 100 || lists[0].Messages[0] != line {
+				t.Errorf("expected the lines to be sent, got %+v", lists)
+			}
+
+			stats := s.Stats()
+			if stats.BytesSent != uint64(len(requests[0].body)) {
+				t.Errorf("expected %d bytes sent, got %d", len(requests[0].body), stats.BytesSent)
+			}
+			compressed := tt.compression != CompressionNone
+			if compressed != (stats.BytesSent < stats.BytesUncompressed/10) {
+				t.Errorf("expected compressed to be %t, got %d bytes of %d", compressed, stats.BytesSent, stats.BytesUncompressed)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package shipper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/humio/cli/internal/api"
+)
+
+func TestLogShipperSendsBatchesConcurrently(t *testing.T) {
+	const concurrency = 4
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	arrived := make(chan struct{}, concurrency)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		<-release
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	address, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestShipper(api.NewClient(api.Config{Address: address, Token: "token"}), "api/v1/ingest/humio-unstructured", func(s *LogShipper) {
+		s.Concurrency = concurrency
+	})
+	for i := range concurrency * 2 {
+		s.HandleLine(fmt.Sprint(i))
+	}
+
+	for range concurrency {
+		select {
+		case <-arrived:
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatalf("expected %d requests at the same time", concurrency)
+		}
+	}
+	close(release)
+	s.Finish()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxInFlight != concurrency {
+		t.Errorf("expected at most %d requests at the same time, got %d", concurrency, maxInFlight)
+	}
+	if stats := s.Stats(); stats.EventsSent != concurrency*2 {
+		t.Errorf("expected %d events sent, got %d", concurrency*2, stats.EventsSent)
+	}
+}
+
+func TestLogShipperOrderPerSource(t *testing.T) {
+	ingest, client := newFakeIngest(t)
+	s := newTestShipper(client, "api/v1/ingest/humio-unstructured", func(s *LogShipper) {
+		s.Concurrency = 4
+		s.OrderPerSource = true
+	})
+
+	sources := make([]Handler, 8)
+	for i := range sources {
+		sources[i] = s.WithFields(map[string]string{"source": fmt.Sprint(i)})
+	}
+	for n := range 50 {
+		for _, source := range sources {
+			source.HandleLine(fmt.Sprint(n))
+		}
+	}
+	s.Finish()
+
+	next := map[string]int{}
+	for _, r := range ingest.received() {
+		var lists []eventList
+		if err := json.Unmarshal(r.body, &lists); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range lists {
+			for _, m := range l.Messages {
+				source := l.Fields["source"]
+				if m != fmt.Sprint(next[source]) {
+					t.Fatalf("expected %d from source %s, got %s", next[source], source, m)
+				}
+				next[source]++
+			}
+		}
+	}
+	if len(next) != len(sources) {
+		t.Errorf("expected events from %d sources, got %d", len(sources), len(next))
+	}
+}
+
+func TestEventPartition(t *testing.T) {
+	a := event{fields: map[string]string{"file": "a.log", "host": "web-1"}}
+	sameSource := event{line: "other", fields: map[string]string{"host": "web-1", "file": "a.log"}}
+	if a.partition(16) != sameSource.partition(16) {
+		t.Error("expected events with the same fields to be in the same partition")
+	}
+
+	tagged := event{structured: &Event{Tags: map[string]string{"host": "web-1"}}}
+	if tagged.partition(16) != (event{structured: &Event{RawString: "other", Tags: map[string]string{"host": "web-1"}}}).partition(16) {
+		t.Error("expected events with the same tags to be in the same partition")
+	}
+
+	seen := map[int]bool{}
+	for i := range 100 {
+		p := event{fields: map[string]string{"file": fmt.Sprint(i)}}.partition(4)
+		if p < 0 || p >= 4 {
+			t.Fatalf("expected a partition below 4, got %d", p)
+		}
+		seen[p] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("expected sources to be spread over all partitions, got %v", seen)
+	}
+
+	if p := a.partition(1); p != 0 {
+		t.Errorf("expected a single partition, got %d", p)
+	}
+}
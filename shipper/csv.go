@@ -0,0 +1,140 @@
+package shipper
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CSVLineHandler parses each line as a CSV row and passes it on as a
+// structured event with the values keyed by the column names. A row may span
+// several lines if a quoted value contains newlines. Values that look like
+// numbers or booleans are sent as such, and empty values are left out.
+type CSVLineHandler struct {
+	EventHandler EventHandler
+	// Delimiter separates the values of a row. Defaults to a comma.
+	Delimiter rune
+	// Header holds the column names. If empty, the first row is used as the header.
+	Header []string
+	// TimestampColumn is the column holding the timestamp of the event. It is removed from the attributes
+	// when it can be parsed. If empty, or the value cannot be parsed, Humio uses the time of ingestion.
+	TimestampColumn string
+	// TimestampFormat is passed to ParseTimestamp.
+	TimestampFormat string
+	// TagColumns are the columns sent as tags instead of attributes.
+	TagColumns []string
+	Logger     func(format string, v ...interface{})
+
+	// pending holds the lines of a row with an unterminated quoted value.
+	pending         []string
+	warnedTimestamp bool
+}
+
+func (h *CSVLineHandler) HandleLine(line string) {
+	if line == "" && len(h.pending) == 0 {
+		return
+	}
+
+	h.pending = append(h.pending, line)
+	row := strings.Join(h.pending, "\n")
+	if strings.Count(row, `"`)%2 == 1 {
+		return
+	}
+	h.pending = nil
+
+	values, err := h.parseRow(row)
+	if err != nil {
+		if h.Logger != nil {
+			h.Logger("Could not parse CSV row, sending it without fields: %v", err)
+		}
+		h.EventHandler.HandleEvent(Event{RawString: row})
+		return
+	}
+
+	if len(h.Header) == 0 {
+		h.Header = values
+		return
+	}
+
+	e := Event{RawString: row, Attributes: make(map[string]interface{}, len(values))}
+
+	for i, v := range values {
+		name := fmt.Sprintf("column%d", i+1)
+		if i < len(h.Header) && h.Header[i] != "" {
+			name = h.Header[i]
+		}
+
+		switch {
+		case v == "":
+		case name == h.TimestampColumn:
+			ts, err := ParseTimestamp(v, h.TimestampFormat)
+			if err == nil {
+				e.Timestamp = ts
+				continue
+			}
+			if !h.warnedTimestamp && h.Logger != nil {
+				h.Logger("Could not parse timestamp, using the time of ingestion: %v", err)
+				h.warnedTimestamp = true
+			}
+			e.Attributes[name] = typedCSVValue(v)
+		case h.isTag(name):
+			if e.Tags == nil {
+				e.Tags = map[string]string{}
+			}
+			e.Tags[name] = v
+		default:
+			e.Attributes[name] = typedCSVValue(v)
+		}
+	}
+
+	h.EventHandler.HandleEvent(e)
+}
+
+// Finish passes on a row with an unterminated quoted value, if any, as an event without fields.
+func (h *CSVLineHandler) Finish() {
+	if len(h.pending) > 0 {
+		h.EventHandler.HandleEvent(Event{RawString: strings.Join(h.pending, "\n")})
+		h.pending = nil
+	}
+}
+
+func (h *CSVLineHandler) parseRow(row string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(row))
+	if h.Delimiter != 0 {
+		r.Comma = h.Delimiter
+	}
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	return r.Read()
+}
+
+func (h *CSVLineHandler) isTag(name string) bool {
+	for _, t := range h.TagColumns {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// typedCSVValue returns v as an integer, a float or a boolean if it is one, and as a string otherwise.
+// Numbers with leading zeros, such as zip codes, are kept as strings.
+func typedCSVValue(v string) interface{} {
+	if len(v) > 1 && v[0] == '0' && v[1] >= '0' && v[1] <= '9' {
+		return v
+	}
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil && !strings.ContainsAny(v, "xXnN") {
+		return f
+	}
+	switch strings.ToLower(v) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return v
+}
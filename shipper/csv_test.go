@@ -0,0 +1,163 @@
+package shipper
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventCollector records the events it is given.
+type eventCollector struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (c *eventCollector) HandleEvent(e Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, e)
+}
+
+func (c *eventCollector) collected() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event(nil), c.events...)
+}
+
+func TestCSVLineHandler(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		handler *CSVLineHandler
+		lines   []string
+		want    []Event
+	}{
+		{
+			name:    "first row is the header",
+			handler: &CSVLineHandler{},
+			lines:   []string{"name,count", "alice,3", "", "bob,4"},
+			want: []Event{
+				{RawString: "alice,3", Attributes: map[string]interface{}{"name": "alice", "count": int64(3)}},
+				{RawString: "bob,4", Attributes: map[string]interface{}{"name": "bob", "count": int64(4)}},
+			},
+		},
+		{
+			name:    "explicit header",
+			handler: &CSVLineHandler{Header: []string{"name", ""}},
+			lines:   []string{"alice,3,extra"},
+			want: []Event{
+				{RawString: "alice,3,extra", Attributes: map[string]interface{}{"name": "alice", "column2": int64(3), "column3": "extra"}},
+			},
+		},
+		{
+			name:    "quoted value spanning lines",
+			handler: &CSVLineHandler{Header: []string{"name", "message"}},
+			lines:   []string{`alice,"first`, ``, `second, ""quoted"""`, "bob,done"},
+			want: []Event{
+				{
+					RawString:  "alice,\"first\n\nsecond, \"\"quoted\"\"\"",
+					Attributes: map[string]interface{}{"name": "alice", "message": "first\n\nsecond, \"quoted\""},
+				},
+				{RawString: "bob,done", Attributes: map[string]interface{}{"name": "bob", "message": "done"}},
+			},
+		},
+		{
+			name:    "empty values are left out",
+			handler: &CSVLineHandler{Header: []string{"a", "b", "c"}},
+			lines:   []string{"1,,3"},
+			want:    []Event{{RawString: "1,,3", Attributes: map[string]interface{}{"a": int64(1), "c": int64(3)}}},
+		},
+		{
+			name:    "delimiter and tag columns",
+			handler: &CSVLineHandler{Delimiter: '\t', Header: []string{"host", "level"}, TagColumns: []string{"host"}},
+			lines:   []string{"web-1\twarn"},
+			want: []Event{
+				{RawString: "web-1\twarn", Attributes: map[string]interface{}{"level": "warn"}, Tags: map[string]string{"host": "web-1"}},
+			},
+		},
+		{
+			name:    "timestamp column",
+			handler: &CSVLineHandler{TimestampColumn: "time"},
+			lines:   []string{"time,msg", "2024-03-01T12:00:00Z,up", "yesterday,down"},
+			want: []Event{
+				{RawString: "2024-03-01T12:00:00Z,up", Timestamp: ts, Attributes: map[string]interface{}{"msg": "up"}},
+				{RawString: "yesterday,down", Attributes: map[string]interface{}{"time": "yesterday", "msg": "down"}},
+			},
+		},
+		{
+			name:    "timestamp column with format",
+			handler: &CSVLineHandler{Header: []string{"ts", "msg"}, TimestampColumn: "ts", TimestampFormat: TimestampFormatUnix},
+			lines:   []string{"1709294400,up"},
+			want:    []Event{{RawString: "1709294400,up", Timestamp: ts, Attributes: map[string]interface{}{"msg": "up"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &eventCollector{}
+			tt.handler.EventHandler = collector
+			for _, line := range tt.lines {
+				tt.handler.HandleLine(line)
+			}
+			tt.handler.Finish()
+			got := collector.collected()
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d events, got %+v", len(tt.want), got)
+			}
+			for i := range got {
+				if !got[i].Timestamp.Equal(tt.want[i].Timestamp) {
+					t.Errorf("expected timestamp %v, got %v", tt.want[i].Timestamp, got[i].Timestamp)
+				}
+				got[i].Timestamp, tt.want[i].Timestamp = time.Time{}, time.Time{}
+				if !reflect.DeepEqual(got[i], tt.want[i]) {
+					t.Errorf("expected %+v, got %+v", tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCSVLineHandlerFinishSendsPendingRow(t *testing.T) {
+	collector := &eventCollector{}
+	h := &CSVLineHandler{EventHandler: collector, Header: []string{"name", "message"}}
+	h.HandleLine(`alice,"unterminated`)
+	if got := collector.collected(); len(got) != 0 {
+		t.Fatalf("expected the row to be pending, got %+v", got)
+	}
+	h.Finish()
+	want := []Event{{RawString: `alice,"unterminated`}}
+	if got := collector.collected(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestTypedCSVValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  interface{}
+	}{
+		{"42", int64(42)},
+		{"-7", int64(-7)},
+		{"0", int64(0)},
+		{"1.5", 1.5},
+		{"1e3", 1000.0},
+		{"007", "007"},
+		{"0.5", 0.5},
+		{"true", true},
+		{"FALSE", false},
+		{"0x1F", "0x1F"},
+		{"NaN", "NaN"},
+		{"Inf", "Inf"},
+		{"yes", "yes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := typedCSVValue(tt.value); got != tt.want {
+				t.Errorf("expected %#v, got %#v", tt.want, got)
+			}
+		})
+	}
+}
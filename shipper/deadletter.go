@@ -0,0 +1,100 @@
+package shipper
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetterRecord is a batch that could not be sent, as written to a dead-letter file.
+type DeadLetterRecord struct {
+	Time   time.Time `json:"time"`
+	URL    string    `json:"url"`
+	Error  string    `json:"error"`
+	Events int       `json:"events"`
+	// Body is the uncompressed request body of the batch.
+	Body string `json:"body"`
+}
+
+// DeadLetterFile appends batches that could not be sent to a file, one JSON record per line.
+type DeadLetterFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenDeadLetterFile opens path for appending, creating it if needed.
+func OpenDeadLetterFile(path string) (*DeadLetterFile, error) {
+	// #nosec G304
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open dead-letter file: %w", err)
+	}
+
+	return &DeadLetterFile{path: path, file: f}, nil
+}
+
+func (d *DeadLetterFile) Path() string {
+	return d.path
+}
+
+func (d *DeadLetterFile) Write(record DeadLetterRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := d.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write to dead-letter file: %w", err)
+	}
+
+	return d.file.Sync()
+}
+
+func (d *DeadLetterFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.file.Close()
+}
+
+// ReadDeadLetterRecords calls handle for each record in a dead-letter file, stopping at the first error.
+func ReadDeadLetterRecords(r io.Reader, handle func(record DeadLetterRecord) error) error {
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var record DeadLetterRecord
+			if jsonErr := json.Unmarshal(line, &record); jsonErr != nil {
+				return fmt.Errorf("could not parse dead-letter record on line %d: %w", lineNumber, jsonErr)
+			}
+			if handleErr := handle(record); handleErr != nil {
+				return handleErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Resend sends a batch from a dead-letter file again, using the retry policy and compression of the shipper.
+// The error behaviour of the shipper is not applied; the error is returned instead.
+func (s *LogShipper) Resend(record DeadLetterRecord) error {
+	encode := func(w io.Writer) error {
+		_, err := io.WriteString(w, record.Body)
+		return err
+	}
+
+	return s.sendWithRetries(record.URL, encode)
+}
@@ -0,0 +1,75 @@
+package shipper
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogShipperWritesDeadLetters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.ndjson")
+	deadLetter, err := OpenDeadLetterFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ingest, client := newFakeIngest(t, http.StatusInternalServerError)
+	s := newTestShipper(client, "api/v1/ingest/humio-unstructured", func(s *LogShipper) {
+		s.DeadLetter = deadLetter
+	})
+	s.HandleLine("failed")
+	s.HandleLine("sent")
+	s.Finish()
+	if err := deadLetter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := ingest.received()
+	if len(requests) != 2 {
+		t.Fatalf("expected two requests, got %d", len(requests))
+	}
+	if stats := s.Stats(); stats.EventsDeadLettered != 1 || stats.EventsDropped != 1 || stats.EventsSent != 1 {
+		t.Errorf("expected one event to be dead-lettered, got %+v", stats)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []DeadLetterRecord
+	err = ReadDeadLetterRecords(f, func(record DeadLetterRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected one record, got %+v", records)
+	}
+	record := records[0]
+	if record.URL != s.URL || record.Events != 1 || record.Body != string(requests[0].body) || !strings.Contains(record.Error, "500") {
+		t.Errorf("expected the failed batch to be recorded, got %+v", record)
+	}
+
+	replayed, replayClient := newFakeIngest(t)
+	replayer := &LogShipper{APIClient: replayClient, MaxAttemptsPerBatch: 1}
+	if err := replayer.Resend(record); err != nil {
+		t.Fatal(err)
+	}
+	got := replayed.received()
+	if len(got) != 1 || got[0].path != "/"+record.URL || string(got[0].body) != record.Body {
+		t.Errorf("expected the batch to be sent again as it was, got %+v", got)
+	}
+}
+
+func TestReadDeadLetterRecordsReportsLine(t *testing.T) {
+	input := "{\"url\":\"a\",\"events\":1}\nnot json\n"
+	err := ReadDeadLetterRecords(strings.NewReader(input), func(DeadLetterRecord) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error for line 2, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package shipper
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FieldExtractor turns each line into a structured event, with fields
+// extracted from the line as attributes. The line is kept as the raw string.
+// Extraction is done client-side, for repositories where the parser cannot be
+// changed.
+type FieldExtractor struct {
+	EventHandler EventHandler
+	// Regexes are matched against each line in turn, and the named capture groups
+	// of each regex that matches become fields. Later regexes overwrite the fields of earlier ones.
+	Regexes []*regexp.Regexp
+	// KeyValues extracts key=value pairs, as in logfmt. Values may be double quoted.
+	KeyValues bool
+	// TimestampField is the field holding the timestamp of the event. It is removed from the attributes
+	// when it can be parsed. If empty, or the value cannot be parsed, Humio uses the time of ingestion.
+	TimestampField string
+	// TimestampRegex, if set, finds the timestamp in the line instead of TimestampField.
+	// The first capture group is the timestamp, or the whole match if there are none.
+	TimestampRegex *regexp.Regexp
+	// TimestampFormat is passed to ParseTimestamp.
+	TimestampFormat string
+}
+
+func (h *FieldExtractor) HandleLine(line string) {
+	attributes := map[string]interface{}{}
+
+	if h.KeyValues {
+		for key, value := range ParseKeyValues(line) {
+			attributes[key] = value
+		}
+	}
+
+	for _, r := range h.Regexes {
+		match := r.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		for i, name := range r.SubexpNames() {
+			if name != "" && i < len(match) {
+				attributes[name] = match[i]
+			}
+		}
+	}
+
+	e := Event{RawString: line, Attributes: attributes}
+
+	if h.TimestampRegex != nil {
+		if match := h.TimestampRegex.FindStringSubmatch(line); match != nil {
+			v := match[0]
+			if len(match) > 1 {
+				v = match[1]
+			}
+			if ts, err := ParseTimestamp(v, h.TimestampFormat); err == nil {
+				e.Timestamp = ts
+			}
+		}
+	} else if h.TimestampField != "" {
+		if v, ok := attributes[h.TimestampField]; ok {
+			if ts, err := ParseTimestamp(v, h.TimestampFormat); err == nil {
+				e.Timestamp = ts
+				delete(attributes, h.TimestampField)
+			}
+		}
+	}
+
+	h.EventHandler.HandleEvent(e)
+}
+
+// ParseKeyValues returns the key=value pairs in line, such as
+// 'level=info msg="user logged in" user=42'. Words without an equals sign are
+// skipped, and a key without a value gets the empty string.
+func ParseKeyValues(line string) map[string]string {
+	pairs := map[string]string{}
+
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		keyEnd := i
+		for keyEnd < len(line) && !strings.ContainsRune(" \t=\"", rune(line[keyEnd])) {
+			keyEnd++
+		}
+		key := line[i:keyEnd]
+		i = keyEnd
+
+		if i >= len(line) || line[i] != '=' {
+			// Not a key, skip the rest of the word.
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				i++
+			}
+			continue
+		}
+		i++
+
+		var value string
+		value, i = parseKeyValueValue(line, i)
+		if key != "" {
+			pairs[key] = value
+		}
+	}
+
+	return pairs
+}
+
+// parseKeyValueValue returns the value starting at line[i] and the index after it.
+func parseKeyValueValue(line string, i int) (string, int) {
+	if i >= len(line) || line[i] != '"' {
+		end := i
+		for end < len(line) && line[end] != ' ' && line[end] != '\t' {
+			end++
+		}
+		return line[i:end], end
+	}
+
+	end := i + 1
+	for end < len(line) && line[end] != '"' {
+		if line[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(line) {
+		// Unterminated quote, take the rest of the line.
+		return line[i+1:], len(line)
+	}
+
+	quoted := line[i : end+1]
+	if value, err := strconv.Unquote(quoted); err == nil {
+		return value, end + 1
+	}
+	return quoted[1 : len(quoted)-1], end + 1
+}
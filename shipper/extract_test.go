@@ -0,0 +1,100 @@
+package shipper
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	tests := []struct {
+		line string
+		want map[string]string
+	}{
+		{`level=info msg="user logged in" user=42`, map[string]string{"level": "info", "msg": "user logged in", "user": "42"}},
+		{"a=1\tb=2", map[string]string{"a": "1", "b": "2"}},
+		{`plain words key= other=x`, map[string]string{"key": "", "other": "x"}},
+		{`msg="say \"hi\"" a=b`, map[string]string{"msg": `say "hi"`, "a": "b"}},
+		{`path="C:\dir" a=b`, map[string]string{"path": `C:\dir`, "a": "b"}},
+		{`msg="unterminated value`, map[string]string{"msg": "unterminated value"}},
+		{`=value k=v`, map[string]string{"k": "v"}},
+		{`url=http://x/?a=b`, map[string]string{"url": "http://x/?a=b"}},
+		{``, map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := ParseKeyValues(tt.line); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFieldExtractor(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		extractor *FieldExtractor
+		line      string
+		want      Event
+	}{
+		{
+			name:      "regexes",
+			extractor: &FieldExtractor{Regexes: []*regexp.Regexp{regexp.MustCompile(`^(?P<method>\w+) (?P<path>\S+)`), regexp.MustCompile(`(?P<path>/\w+)$`)}},
+			line:      "GET /index.html /last",
+			want:      Event{RawString: "GET /index.html /last", Attributes: map[string]interface{}{"method": "GET", "path": "/last"}},
+		},
+		{
+			name:      "regexes overwrite key values",
+			extractor: &FieldExtractor{KeyValues: true, Regexes: []*regexp.Regexp{regexp.MustCompile(`user=(?P<user>\d+)`), regexp.MustCompile(`(?P<missing>nomatch)`)}},
+			line:      "user=42x level=warn",
+			want:      Event{RawString: "user=42x level=warn", Attributes: map[string]interface{}{"user": "42", "level": "warn"}},
+		},
+		{
+			name:      "timestamp field",
+			extractor: &FieldExtractor{KeyValues: true, TimestampField: "ts", TimestampFormat: TimestampFormatUnix},
+			line:      "ts=1709294400 msg=up",
+			want:      Event{Timestamp: ts, RawString: "ts=1709294400 msg=up", Attributes: map[string]interface{}{"msg": "up"}},
+		},
+		{
+			name:      "unparsable timestamp field is kept",
+			extractor: &FieldExtractor{KeyValues: true, TimestampField: "ts"},
+			line:      "ts=yesterday",
+			want:      Event{RawString: "ts=yesterday", Attributes: map[string]interface{}{"ts": "yesterday"}},
+		},
+		{
+			name:      "timestamp regex",
+			extractor: &FieldExtractor{KeyValues: true, TimestampRegex: regexp.MustCompile(`^\[([^\]]+)\]`), TimestampFormat: "02/Jan/2006:15:04:05"},
+			line:      "[01/Mar/2024:12:00:00] level=info",
+			want:      Event{Timestamp: ts, RawString: "[01/Mar/2024:12:00:00] level=info", Attributes: map[string]interface{}{"level": "info"}},
+		},
+		{
+			name:      "nothing extracted",
+			extractor: &FieldExtractor{Regexes: []*regexp.Regexp{regexp.MustCompile(`(?P<a>x)`)}},
+			line:      "plain",
+			want:      Event{RawString: "plain", Attributes: map[string]interface{}{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &eventCollector{}
+			tt.extractor.EventHandler = collector
+			tt.extractor.HandleLine(tt.line)
+			got := collector.collected()
+			if len(got) != 1 {
+				t.Fatalf("expected one event, got %+v", got)
+			}
+			if !got[0].Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("expected timestamp %v, got %v", tt.want.Timestamp, got[0].Timestamp)
+			}
+			got[0].Timestamp, tt.want.Timestamp = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got[0], tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got[0])
+			}
+		})
+	}
+}
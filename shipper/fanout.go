@@ -0,0 +1,112 @@
+package shipper
+
+import "sync"
+
+// FanOut passes each line and event on to several shippers. Each shipper is
+// fed from its own buffer, so a slow shipper only holds up the others once
+// its buffer is full.
+type FanOut struct {
+	shippers []*LogShipper
+	queues   []chan fanOutItem
+	wg       sync.WaitGroup
+}
+
+type fanOutItem struct {
+	line       string
+	event      *Event
+	fields     map[string]string
+	checkpoint func()
+}
+
+// NewFanOut starts feeding the shippers, buffering up to bufferSize lines and events for each.
+// The shippers must already be started.
+func NewFanOut(bufferSize int, shippers ...*LogShipper) *FanOut {
+	f := &FanOut{shippers: shippers}
+
+	for _, s := range shippers {
+		queue := make(chan fanOutItem, bufferSize)
+		f.queues = append(f.queues, queue)
+
+		f.wg.Add(1)
+		go func(s *LogShipper) {
+			defer f.wg.Done()
+			for item := range queue {
+				if item.checkpoint != nil {
+					s.Checkpoint(item.checkpoint)
+					continue
+				}
+				var handler Handler = s
+				if item.fields != nil {
+					handler = s.WithFields(item.fields)
+				}
+				if item.event != nil {
+					handler.HandleEvent(*item.event)
+				} else {
+					handler.HandleLine(item.line)
+				}
+			}
+		}(s)
+	}
+
+	return f
+}
+
+func (f *FanOut) HandleLine(line string) {
+	f.handle(fanOutItem{line: line})
+}
+
+func (f *FanOut) HandleEvent(e Event) {
+	f.handle(fanOutItem{event: &e})
+}
+
+// WithFields returns a Handler adding fields to each line and event, like LogShipper.WithFields.
+func (f *FanOut) WithFields(fields map[string]string) Handler {
+	return &fanOutFieldsHandler{fanOut: f, fields: fields}
+}
+
+// Checkpoint calls done once the lines and events handled so far are settled
+// by all the shippers, as described for LogShipper.Checkpoint.
+func (f *FanOut) Checkpoint(done func()) {
+	var mu sync.Mutex
+	remaining := len(f.queues)
+	if remaining == 0 {
+		done()
+		return
+	}
+	f.handle(fanOutItem{checkpoint: func() {
+		mu.Lock()
+		remaining--
+		last := remaining == 0
+		mu.Unlock()
+		if last {
+			done()
+		}
+	}})
+}
+
+// Finish waits for the buffered lines and events to be handed to the shippers. It does not finish the shippers.
+func (f *FanOut) Finish() {
+	for _, queue := range f.queues {
+		close(queue)
+	}
+	f.wg.Wait()
+}
+
+func (f *FanOut) handle(item fanOutItem) {
+	for _, queue := range f.queues {
+		queue <- item
+	}
+}
+
+type fanOutFieldsHandler struct {
+	fanOut *FanOut
+	fields map[string]string
+}
+
+func (h *fanOutFieldsHandler) HandleLine(line string) {
+	h.fanOut.handle(fanOutItem{line: line, fields: h.fields})
+}
+
+func (h *fanOutFieldsHandler) HandleEvent(e Event) {
+	h.fanOut.handle(fanOutItem{event: &e, fields: h.fields})
+}
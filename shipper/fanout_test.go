@@ -0,0 +1,50 @@
+package shipper
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFanOutContinuesPastFailingDestination(t *testing.T) {
+	healthy, healthyClient := newFakeIngest(t)
+	failing, failingClient := newFakeIngest(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+	url := "api/v1/repositories/logs/ingest-messages"
+	healthyShipper := newTestShipper(healthyClient, url, nil)
+	failingShipper := newTestShipper(failingClient, url, nil)
+	f := NewFanOut(10, healthyShipper, failingShipper)
+
+	checkpointed := make(chan struct{})
+	for _, line := range []string{"first", "second", "third"} {
+		f.HandleLine(line)
+	}
+	f.WithFields(map[string]string{"host": "web-1"}).HandleLine("fourth")
+	f.Checkpoint(func() { close(checkpointed) })
+	f.Finish()
+	healthyShipper.Finish()
+	failingShipper.Finish()
+
+	received := healthy.received()
+	if len(received) != 4 {
+		t.Fatalf("expected the healthy destination to receive 4 batches, got %d", len(received))
+	}
+	if body := string(received[3].body); !strings.Contains(body, `"host":"web-1"`) || !strings.Contains(body, "fourth") {
+		t.Errorf("expected the last batch to have the fields of its line, got %s", body)
+	}
+	if n := len(failing.received()); n != 4 {
+		t.Errorf("expected the failing destination to be sent 4 batches, got %d", n)
+	}
+	if stats := healthyShipper.Stats(); stats.EventsSent != 4 || stats.EventsDropped != 0 {
+		t.Errorf("expected all events to be sent to the healthy destination, got %+v", stats)
+	}
+	if stats := failingShipper.Stats(); stats.EventsSent != 2 || stats.EventsDropped != 2 {
+		t.Errorf("expected 2 events to be dropped by the failing destination, got %+v", stats)
+	}
+
+	select {
+	case <-checkpointed:
+	default:
+		t.Error("expected the checkpoint to be called back once both destinations settled the events")
+	}
+}
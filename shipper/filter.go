@@ -0,0 +1,121 @@
+package shipper
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// LineFilter decides which lines are kept. Filters are safe for concurrent
+// use, so one filter can be shared by the handler chains of several sources.
+type LineFilter interface {
+	Keep(line string) bool
+	// Dropped is the number of lines that were not kept.
+	Dropped() uint64
+}
+
+// FilterHandler passes on the lines kept by Filter and drops the rest.
+type FilterHandler struct {
+	LineHandler LineHandler
+	Filter      LineFilter
+}
+
+func (h *FilterHandler) HandleLine(line string) {
+	if h.Filter.Keep(line) {
+		h.LineHandler.HandleLine(line)
+	}
+}
+
+// RegexFilter keeps the lines that match at least one of Include, or all lines if
+// Include is empty, except the lines that match any of Exclude.
+type RegexFilter struct {
+	Include []*regexp.Regexp
+	Exclude []*regexp.Regexp
+
+	dropped atomic.Uint64
+}
+
+func (f *RegexFilter) Keep(line string) bool {
+	keep := len(f.Include) == 0
+	for _, r := range f.Include {
+		if r.MatchString(line) {
+			keep = true
+			break
+		}
+	}
+	for _, r := range f.Exclude {
+		if !keep {
+			break
+		}
+		keep = !r.MatchString(line)
+	}
+
+	if !keep {
+		f.dropped.Add(1)
+	}
+	return keep
+}
+
+func (f *RegexFilter) Dropped() uint64 {
+	return f.dropped.Load()
+}
+
+// Sampler keeps 1 in Rate lines. If KeyField is set, the decision is made on a
+// hash of the value of that field, so all lines with the same value are either
+// kept or dropped. The field is looked up in key=value pairs, or in the line
+// as a JSON object. Lines without the field, or all lines if KeyField is not
+// set, are sampled by keeping every Rate'th line.
+type Sampler struct {
+	Rate     int
+	KeyField string
+
+	count   atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func (s *Sampler) Keep(line string) bool {
+	if s.Rate <= 1 {
+		return true
+	}
+
+	var keep bool
+	if key, ok := s.key(line); ok {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		keep = h.Sum64()%uint64(s.Rate) == 0
+	} else {
+		keep = (s.count.Add(1)-1)%uint64(s.Rate) == 0
+	}
+
+	if !keep {
+		s.dropped.Add(1)
+	}
+	return keep
+}
+
+func (s *Sampler) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Sampler) key(line string) (string, bool) {
+	if s.KeyField == "" {
+		return "", false
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(line), "{") {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &object); err == nil {
+			v, ok := object[s.KeyField]
+			if !ok {
+				return "", false
+			}
+			return fmt.Sprint(v), true
+		}
+	}
+
+	v, ok := ParseKeyValues(line)[s.KeyField]
+	return v, ok
+}
@@ -0,0 +1,110 @@
+package shipper
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestRegexFilter(t *testing.T) {
+	lines := []string{"GET /health 200", "GET /index.html 200", "POST /login 500", "debug: cache miss"}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{"no patterns", nil, nil, lines},
+		{"include", []string{`^GET`, `^POST`}, nil, lines[:3]},
+		{"exclude", nil, []string{`/health`, `^debug`}, lines[1:3]},
+		{"include and exclude", []string{`^GET`}, []string{`/health`}, lines[1:2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &RegexFilter{}
+			for _, r := range tt.include {
+				f.Include = append(f.Include, regexp.MustCompile(r))
+			}
+			for _, r := range tt.exclude {
+				f.Exclude = append(f.Exclude, regexp.MustCompile(r))
+			}
+			collector := &lineCollector{}
+			h := &FilterHandler{LineHandler: collector, Filter: f}
+			for _, line := range lines {
+				h.HandleLine(line)
+			}
+
+			if got := collector.collected(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+			if dropped := f.Dropped(); dropped != uint64(len(lines)-len(tt.want)) {
+				t.Errorf("expected %d lines dropped, got %d", len(lines)-len(tt.want), dropped)
+			}
+		})
+	}
+}
+
+func TestSamplerKeepsEveryRateLine(t *testing.T) {
+	s := &Sampler{Rate: 4}
+	var kept []int
+	for i := range 12 {
+		if s.Keep(fmt.Sprint(i)) {
+			kept = append(kept, i)
+		}
+	}
+	if want := []int{0, 4, 8}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("expected %v, got %v", want, kept)
+	}
+	if s.Dropped() != 9 {
+		t.Errorf("expected 9 lines dropped, got %d", s.Dropped())
+	}
+
+	all := &Sampler{Rate: 1}
+	for i := range 10 {
+		if !all.Keep(fmt.Sprint(i)) {
+			t.Fatal("expected a rate of 1 to keep all lines")
+		}
+	}
+}
+
+func TestSamplerKeyField(t *testing.T) {
+	s := &Sampler{Rate: 3, KeyField: "trace"}
+
+	kept := map[string]int{}
+	total := map[string]int{}
+	for i := range 30 {
+		trace := fmt.Sprint("t", i%10)
+		for _, line := range []string{
+			fmt.Sprintf("level=info trace=%s n=%d", trace, i),
+			fmt.Sprintf(`{"trace": %q, "n": %d}`, trace, i),
+		} {
+			total[trace]++
+			if s.Keep(line) {
+				kept[trace]++
+			}
+		}
+	}
+
+	if len(kept) == 0 || len(kept) == len(total) {
+		t.Fatalf("expected some but not all traces to be kept, got %v", kept)
+	}
+	for trace, n := range kept {
+		if n != total[trace] {
+			t.Errorf("expected all or none of the lines of trace %s to be kept, got %d of %d", trace, n, total[trace])
+		}
+	}
+
+	// Lines without the field are sampled by count.
+	var withoutKey int
+	for i := range 9 {
+		if s.Keep(fmt.Sprint("no key ", i)) {
+			withoutKey++
+		}
+	}
+	if withoutKey != 3 {
+		t.Errorf("expected 3 of 9 lines without the key to be kept, got %d", withoutKey)
+	}
+}
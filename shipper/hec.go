@@ -0,0 +1,158 @@
+package shipper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// HECMetadata holds the HEC metadata keys used for events that do not set them.
+type HECMetadata struct {
+	Host       string
+	Source     string
+	SourceType string
+	Index      string
+}
+
+func (m HECMetadata) isZero() bool {
+	return m == HECMetadata{}
+}
+
+// writeHECEvents writes the batch as newline separated HEC event objects.
+//
+// Lines that already are one or more HEC event objects are forwarded as they
+// are, only adding fields and default metadata where needed. Other lines are
+// sent as the event of a new HEC object. Structured events are mapped with
+// the tags host, source, sourcetype and index becoming HEC metadata and the
+// attributes and remaining tags becoming HEC fields.
+func (s *LogShipper) writeHECEvents(w io.Writer, batch []event) error {
+	for _, e := range batch {
+		var objects []map[string]json.RawMessage
+		if e.structured != nil {
+			objects = []map[string]json.RawMessage{s.hecFromEvent(e)}
+		} else if objects = decodeHECObjects(e.line); objects == nil {
+			objects = []map[string]json.RawMessage{{"event": rawJSON(e.line)}}
+		} else if len(s.Fields) == 0 && len(e.fields) == 0 && s.HECDefaults.isZero() {
+			// Nothing to add, forward the line unchanged.
+			if _, err := io.WriteString(w, e.line+"\n"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, o := range objects {
+			s.addHECMetadata(o, e.fields)
+			data, err := json.Marshal(o)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(append(data, '\n')); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *LogShipper) hecFromEvent(e event) map[string]json.RawMessage {
+	o := map[string]json.RawMessage{}
+
+	if !e.structured.Timestamp.IsZero() {
+		o["time"] = rawJSON(float64(e.structured.Timestamp.UnixNano()) / 1e9)
+	}
+
+	fields := map[string]interface{}{}
+	for k, v := range e.structured.Attributes {
+		fields[k] = v
+	}
+	for k, v := range e.structured.Tags {
+		switch k {
+		case "host", "source", "sourcetype", "index":
+			o[k] = rawJSON(v)
+		default:
+			fields[k] = v
+		}
+	}
+
+	if e.structured.RawString != "" || len(fields) == 0 {
+		o["event"] = rawJSON(e.structured.RawString)
+		if len(fields) > 0 {
+			o["fields"] = rawJSON(fields)
+		}
+	} else {
+		o["event"] = rawJSON(fields)
+	}
+
+	return o
+}
+
+// addHECMetadata adds the shipper fields, the given event fields and the default metadata to a HEC object.
+// Values already present in the object take precedence.
+func (s *LogShipper) addHECMetadata(o map[string]json.RawMessage, eventFields map[string]string) {
+	for k, v := range map[string]string{
+		"host":       s.HECDefaults.Host,
+		"source":     s.HECDefaults.Source,
+		"sourcetype": s.HECDefaults.SourceType,
+		"index":      s.HECDefaults.Index,
+	} {
+		if _, ok := o[k]; !ok && v != "" {
+			o[k] = rawJSON(v)
+		}
+	}
+
+	if len(s.Fields) == 0 && len(eventFields) == 0 {
+		return
+	}
+
+	fields := map[string]json.RawMessage{}
+	for k, v := range mergeFields(s.Fields, eventFields) {
+		fields[k] = rawJSON(v)
+	}
+	if existing, ok := o["fields"]; ok {
+		var own map[string]json.RawMessage
+		if err := json.Unmarshal(existing, &own); err != nil {
+			return
+		}
+		for k, v := range own {
+			fields[k] = v
+		}
+	}
+	o["fields"] = rawJSON(fields)
+}
+
+// decodeHECObjects returns the HEC event objects in line, or nil if line is not a sequence of HEC event objects.
+func decodeHECObjects(line string) []map[string]json.RawMessage {
+	trimmed := bytes.TrimSpace([]byte(line))
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil
+	}
+
+	var objects []map[string]json.RawMessage
+	d := json.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		var o map[string]json.RawMessage
+		err := d.Decode(&o)
+		if errors.Is(err, io.EOF) {
+			return objects
+		}
+		if err != nil {
+			return nil
+		}
+		if _, ok := o["event"]; !ok {
+			return nil
+		}
+		objects = append(objects, o)
+	}
+}
+
+// rawJSON marshals v, falling back to its string representation if it cannot be marshalled.
+func rawJSON(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return data
+}
@@ -0,0 +1,119 @@
+package shipper
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteHECEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		shipper *LogShipper
+		batch   []event
+		want    []string
+	}{
+		{
+			name:    "plain line",
+			shipper: &LogShipper{},
+			batch:   []event{{line: "GET / 200"}},
+			want:    []string{`{"event":"GET / 200"}`},
+		},
+		{
+			name:    "HEC objects are forwarded unchanged",
+			shipper: &LogShipper{},
+			batch:   []event{{line: `{"event": "a", "host": "h"}  {"event": {"b": 1}}`}},
+			want:    []string{`{"event": "a", "host": "h"}  {"event": {"b": 1}}`},
+		},
+		{
+			name:    "JSON without an event key is a plain line",
+			shipper: &LogShipper{},
+			batch:   []event{{line: `{"msg": "up"}`}},
+			want:    []string{`{"event":"{\"msg\": \"up\"}"}`},
+		},
+		{
+			name: "fields and defaults are added without overriding",
+			shipper: &LogShipper{
+				Fields:      map[string]string{"env": "prod", "team": "ops"},
+				HECDefaults: HECMetadata{Host: "default-host", Index: "main"},
+			},
+			batch: []event{
+				{line: `{"event": "a", "host": "own-host", "fields": {"team": "web"}}`, fields: map[string]string{"file": "a.log"}},
+				{line: "plain"},
+			},
+			want: []string{
+				`{"event":"a","host":"own-host","index":"main","fields":{"env":"prod","team":"web","file":"a.log"}}`,
+				`{"event":"plain","host":"default-host","index":"main","fields":{"env":"prod","team":"ops"}}`,
+			},
+		},
+		{
+			name:    "structured event with raw string",
+			shipper: &LogShipper{},
+			batch: []event{{structured: &Event{
+				Timestamp:  time.Date(2024, 3, 1, 12, 0, 0, 5e8, time.UTC),
+				RawString:  "raw",
+				Attributes: map[string]interface{}{"status": 200},
+				Tags:       map[string]string{"host": "web-1", "sourcetype": "access", "region": "eu"},
+			}}},
+			want: []string{`{"time":1709294400.5,"event":"raw","host":"web-1","sourcetype":"access","fields":{"status":200,"region":"eu"}}`},
+		},
+		{
+			name:    "structured event without raw string",
+			shipper: &LogShipper{},
+			batch:   []event{{structured: &Event{Attributes: map[string]interface{}{"msg": "up"}, Tags: map[string]string{"index": "main"}}}},
+			want:    []string{`{"event":{"msg":"up"},"index":"main"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.shipper.writeHECEvents(&buf, tt.batch); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d lines, got %q", len(tt.want), got)
+			}
+			for i := range got {
+				if got[i] == tt.want[i] {
+					continue
+				}
+				// Keys of marshalled objects are sorted, so compare the objects rather than the text.
+				var gotObject, wantObject interface{}
+				if err := json.Unmarshal([]byte(got[i]), &gotObject); err != nil {
+					t.Fatalf("expected a JSON object, got %s: %v", got[i], err)
+				}
+				if err := json.Unmarshal([]byte(tt.want[i]), &wantObject); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(gotObject, wantObject) {
+					t.Errorf("expected %s, got %s", tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLogShipperSendsHECEvents(t *testing.T) {
+	ingest, client := newFakeIngest(t)
+	s := newTestShipper(client, "api/v1/ingest/hec", func(s *LogShipper) {
+		s.Format = IngestFormatHEC
+		s.BatchSizeLines = 2
+	})
+
+	s.HandleLine("first")
+	s.HandleLine("second")
+	s.Finish()
+
+	requests := ingest.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected one request, got %d", len(requests))
+	}
+	if want := "{\"event\":\"first\"}\n{\"event\":\"second\"}\n"; string(requests[0].body) != want {
+		t.Errorf("expected %q, got %q", want, requests[0].body)
+	}
+}
@@ -0,0 +1,115 @@
+package shipper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timestamp formats understood by ParseTimestamp besides Go time layouts.
+const (
+	TimestampFormatRFC3339    = "rfc3339"
+	TimestampFormatUnix       = "unix"
+	TimestampFormatUnixMillis = "unixmillis"
+)
+
+// JSONLineHandler parses each line as a JSON object and passes it on as a
+// structured event. Lines that are not JSON objects are passed on as events
+// with only a raw string.
+type JSONLineHandler struct {
+	EventHandler EventHandler
+	// TimestampField is the key holding the timestamp of the event. It is removed from the attributes
+	// when it can be parsed. If empty, or the value cannot be parsed, Humio uses the time of ingestion.
+	TimestampField string
+	// TimestampFormat is passed to ParseTimestamp.
+	TimestampFormat string
+	// TagFields are the keys moved from the attributes to the tags of the event.
+	TagFields []string
+	Logger    func(format string, v ...interface{})
+
+	warnedTimestamp bool
+}
+
+func (h *JSONLineHandler) HandleLine(line string) {
+	var attributes map[string]interface{}
+
+	d := json.NewDecoder(strings.NewReader(line))
+	d.UseNumber()
+	if err := d.Decode(&attributes); err != nil || attributes == nil {
+		h.EventHandler.HandleEvent(Event{RawString: line})
+		return
+	}
+
+	e := Event{RawString: line, Attributes: attributes}
+
+	if h.TimestampField != "" {
+		if v, ok := attributes[h.TimestampField]; ok {
+			ts, err := ParseTimestamp(v, h.TimestampFormat)
+			if err == nil {
+				e.Timestamp = ts
+				delete(attributes, h.TimestampField)
+			} else if !h.warnedTimestamp && h.Logger != nil {
+				h.Logger("Could not parse timestamp, using the time of ingestion: %v", err)
+				h.warnedTimestamp = true
+			}
+		}
+	}
+
+	for _, f := range h.TagFields {
+		v, ok := attributes[f]
+		if !ok {
+			continue
+		}
+		if e.Tags == nil {
+			e.Tags = map[string]string{}
+		}
+		e.Tags[f] = stringValue(v)
+		delete(attributes, f)
+	}
+
+	h.EventHandler.HandleEvent(e)
+}
+
+// ParseTimestamp parses v, a string or a number, as a timestamp. The format
+// is either one of the TimestampFormat constants or a Go time layout. An empty
+// format means TimestampFormatRFC3339.
+func ParseTimestamp(v interface{}, format string) (time.Time, error) {
+	switch format {
+	case "", TimestampFormatRFC3339:
+		return time.Parse(time.RFC3339Nano, stringValue(v))
+	case TimestampFormatUnix, TimestampFormatUnixMillis:
+		f, err := strconv.ParseFloat(stringValue(v), 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s timestamp %q: %w", format, stringValue(v), err)
+		}
+		if format == TimestampFormatUnix {
+			f *= 1000
+		}
+		millis := math.Floor(f)
+		return time.UnixMilli(int64(millis)).Add(time.Duration((f - millis) * float64(time.Millisecond))), nil
+	default:
+		return time.Parse(format, stringValue(v))
+	}
+}
+
+// stringValue formats a decoded JSON value as a string, keeping numbers as they were written.
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	case map[string]interface{}, []interface{}:
+		var buf bytes.Buffer
+		_ = json.NewEncoder(&buf).Encode(v)
+		return strings.TrimSuffix(buf.String(), "\n")
+	default:
+		return fmt.Sprint(v)
+	}
+}
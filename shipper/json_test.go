@@ -0,0 +1,163 @@
+package shipper
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSONLineHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler *JSONLineHandler
+		line    string
+		want    Event
+	}{
+		{
+			name:    "attributes",
+			handler: &JSONLineHandler{},
+			line:    `{"msg":"up","count":12345678901234567890,"nested":{"a":1}}`,
+			want: Event{
+				RawString: `{"msg":"up","count":12345678901234567890,"nested":{"a":1}}`,
+				Attributes: map[string]interface{}{
+					"msg":    "up",
+					"count":  json.Number("12345678901234567890"),
+					"nested": map[string]interface{}{"a": json.Number("1")},
+				},
+			},
+		},
+		{
+			name:    "timestamp and tags",
+			handler: &JSONLineHandler{TimestampField: "@t", TagFields: []string{"host", "missing"}},
+			line:    `{"@t":"2024-03-01T12:00:00.5Z","host":"web-1","msg":"up"}`,
+			want: Event{
+				Timestamp:  time.Date(2024, 3, 1, 12, 0, 0, 5e8, time.UTC),
+				RawString:  `{"@t":"2024-03-01T12:00:00.5Z","host":"web-1","msg":"up"}`,
+				Attributes: map[string]interface{}{"msg": "up"},
+				Tags:       map[string]string{"host": "web-1"},
+			},
+		},
+		{
+			name:    "unparsable timestamp is kept",
+			handler: &JSONLineHandler{TimestampField: "ts", TimestampFormat: TimestampFormatUnix},
+			line:    `{"ts":"yesterday"}`,
+			want:    Event{RawString: `{"ts":"yesterday"}`, Attributes: map[string]interface{}{"ts": "yesterday"}},
+		},
+		{
+			name:    "not an object",
+			handler: &JSONLineHandler{},
+			line:    `[1, 2]`,
+			want:    Event{RawString: `[1, 2]`},
+		},
+		{
+			name:    "not json",
+			handler: &JSONLineHandler{TimestampField: "ts"},
+			line:    `plain text`,
+			want:    Event{RawString: `plain text`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &eventCollector{}
+			tt.handler.EventHandler = collector
+			tt.handler.HandleLine(tt.line)
+			got := collector.collected()
+			if len(got) != 1 {
+				t.Fatalf("expected one event, got %+v", got)
+			}
+			if !got[0].Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("expected timestamp %v, got %v", tt.want.Timestamp, got[0].Timestamp)
+			}
+			got[0].Timestamp, tt.want.Timestamp = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got[0], tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got[0])
+			}
+		})
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2024, 3, 1, 12, 0, 0, 250e6, time.UTC)
+
+	tests := []struct {
+		value  interface{}
+		format string
+	}{
+		{"2024-03-01T12:00:00.25Z", ""},
+		{"2024-03-01T13:00:00.25+01:00", TimestampFormatRFC3339},
+		{json.Number("1709294400.25"), TimestampFormatUnix},
+		{"1709294400250", TimestampFormatUnixMillis},
+		{"01/03/2024 12:00:00.25", "02/01/2006 15:04:05"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := ParseTimestamp(tt.value, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		})
+	}
+
+	if _, err := ParseTimestamp("soon", TimestampFormatUnixMillis); err == nil {
+		t.Error("expected an error for an invalid timestamp")
+	}
+}
+
+func TestLogShipperSendsStructuredEvents(t *testing.T) {
+	ingest, client := newFakeIngest(t)
+	s := newTestShipper(client, "api/v1/ingest/humio-structured", func(s *LogShipper) {
+		s.Format = IngestFormatStructured
+		s.Fields = map[string]string{"env": "prod", "msg": "shadowed"}
+		s.BatchSizeLines = 3
+	})
+
+	s.HandleEvent(Event{
+		Timestamp:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		RawString:  "raw",
+		Attributes: map[string]interface{}{"msg": "up"},
+		Tags:       map[string]string{"host": "web-1"},
+	})
+	s.HandleEvent(Event{Attributes: map[string]interface{}{"msg": "down"}, Tags: map[string]string{"host": "web-1"}})
+	s.HandleLine("plain")
+	s.Finish()
+
+	requests := ingest.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected one request, got %d", len(requests))
+	}
+	if requests[0].path != "/api/v1/ingest/humio-structured" {
+		t.Errorf("expected the structured endpoint, got %q", requests[0].path)
+	}
+
+	var got []map[string]interface{}
+	if err := json.Unmarshal(requests[0].body, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{
+			"tags": map[string]interface{}{"host": "web-1"},
+			"events": []interface{}{
+				map[string]interface{}{
+					"timestamp":  "2024-03-01T12:00:00Z",
+					"rawstring":  "raw",
+					"attributes": map[string]interface{}{"env": "prod", "msg": "up"},
+				},
+				map[string]interface{}{"attributes": map[string]interface{}{"env": "prod", "msg": "down"}},
+			},
+		},
+		{
+			"events": []interface{}{
+				map[string]interface{}{"rawstring": "plain", "attributes": map[string]interface{}{"env": "prod", "msg": "shadowed"}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
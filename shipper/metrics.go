@@ -0,0 +1,206 @@
+package shipper
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// sendDurationBuckets are the upper bounds in seconds of the send duration histogram buckets.
+var sendDurationBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type metrics struct {
+	eventsReceived     atomic.Uint64
+	eventsSent         atomic.Uint64
+	eventsDropped      atomic.Uint64
+	eventsDeadLettered atomic.Uint64
+	batchesSent        atomic.Uint64
+	batchesFailed      atomic.Uint64
+	requests           atomic.Uint64
+	retries            atomic.Uint64
+	bytesUncompressed  atomic.Uint64
+	bytesSent          atomic.Uint64
+	lastSuccess        atomic.Int64
+	sendDuration       histogram
+}
+
+// Stats holds counters for a LogShipper. Requests and bytes include retries.
+type Stats struct {
+	// EventsReceived is the number of lines and events handed to the shipper. Lines dropped by filters before
+	// reaching the shipper are not included, and lines joined into multi line events are counted once per event.
+	EventsReceived uint64
+	// LinesFiltered is the number of input lines dropped by filters such as RegexFilter and Sampler. The shipper does
+	// not see these lines, so it is left for the caller to fill in from LineFilter.Dropped.
+	LinesFiltered uint64
+	EventsSent    uint64
+	// EventsDropped is the number of events that could not be sent and were not kept in the spool.
+	EventsDropped      uint64
+	EventsDeadLettered uint64
+	// EventsQueued is the number of events waiting to be put in a batch.
+	EventsQueued  int
+	BatchesSent   uint64
+	BatchesFailed uint64
+	Requests      uint64
+	Retries       uint64
+	// BytesUncompressed is the size of the request bodies before compression.
+	BytesUncompressed uint64
+	// BytesSent is the size of the request bodies as sent over the wire.
+	BytesSent uint64
+	// LastSuccess is when a batch was last sent successfully.
+	LastSuccess time.Time
+	// SendDuration is the distribution of the duration of requests.
+	SendDuration HistogramSnapshot
+}
+
+func (s *LogShipper) Stats() Stats {
+	stats := Stats{
+		EventsReceived:     s.metrics.eventsReceived.Load(),
+		EventsSent:         s.metrics.eventsSent.Load(),
+		EventsDropped:      s.metrics.eventsDropped.Load(),
+		EventsDeadLettered: s.metrics.eventsDeadLettered.Load(),
+		EventsQueued:       len(s.events),
+		BatchesSent:        s.metrics.batchesSent.Load(),
+		BatchesFailed:      s.metrics.batchesFailed.Load(),
+		Requests:           s.metrics.requests.Load(),
+		Retries:            s.metrics.retries.Load(),
+		BytesUncompressed:  s.metrics.bytesUncompressed.Load(),
+		BytesSent:          s.metrics.bytesSent.Load(),
+		SendDuration:       s.metrics.sendDuration.snapshot(),
+	}
+	if lastSuccess := s.metrics.lastSuccess.Load(); lastSuccess != 0 {
+		stats.LastSuccess = time.Unix(0, lastSuccess)
+	}
+	return stats
+}
+
+// WritePrometheus writes the stats of one or more shippers in the Prometheus text exposition format.
+// The stats are keyed by the value of a destination label, or by the empty string to write them without labels.
+func WritePrometheus(w io.Writer, stats map[string]Stats) error {
+	destinations := make([]string, 0, len(stats))
+	for d := range stats {
+		destinations = append(destinations, d)
+	}
+	sort.Strings(destinations)
+
+	var err error
+	write := func(format string, v ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, v...)
+		}
+	}
+	labels := func(destination string, extra ...string) string {
+		if destination != "" {
+			extra = append([]string{fmt.Sprintf("destination=%q", destination)}, extra...)
+		}
+		if len(extra) == 0 {
+			return ""
+		}
+		return "{" + strings.Join(extra, ",") + "}"
+	}
+	metric := func(name, kind, help string, value func(stats Stats) float64) {
+		write("# HELP humioctl_ingest_%s %s\n# TYPE humioctl_ingest_%s %s\n", name, help, name, kind)
+		for _, d := range destinations {
+			write("humioctl_ingest_%s%s %s\n", name, labels(d), formatFloat(value(stats[d])))
+		}
+	}
+
+	metric("events_received_total", "counter", "Lines and events handed to the shipper, after filtering and joining multi line events.", func(s Stats) float64 { return float64(s.EventsReceived) })
+	metric("lines_filtered_total", "counter", "Input lines dropped by --include, --exclude or --sample.", func(s Stats) float64 { return float64(s.LinesFiltered) })
+	metric("events_sent_total", "counter", "Events sent successfully.", func(s Stats) float64 { return float64(s.EventsSent) })
+	metric("events_dropped_total", "counter", "Events that could not be sent and were not kept in the spool.", func(s Stats) float64 { return float64(s.EventsDropped) })
+	metric("events_dead_lettered_total", "counter", "Events written to the dead-letter file.", func(s Stats) float64 { return float64(s.EventsDeadLettered) })
+	metric("events_queued", "gauge", "Events waiting to be put in a batch.", func(s Stats) float64 { return float64(s.EventsQueued) })
+	metric("batches_sent_total", "counter", "Batches sent successfully.", func(s Stats) float64 { return float64(s.BatchesSent) })
+	metric("batches_failed_total", "counter", "Batches that could not be sent after all attempts.", func(s Stats) float64 { return float64(s.BatchesFailed) })
+	metric("requests_total", "counter", "Requests made, including retries.", func(s Stats) float64 { return float64(s.Requests) })
+	metric("retries_total", "counter", "Requests that were retries of a failed request.", func(s Stats) float64 { return float64(s.Retries) })
+	metric("bytes_uncompressed_total", "counter", "Size of the request bodies before compression.", func(s Stats) float64 { return float64(s.BytesUncompressed) })
+	metric("bytes_sent_total", "counter", "Size of the request bodies as sent.", func(s Stats) float64 { return float64(s.BytesSent) })
+	metric("last_success_timestamp_seconds", "gauge", "Unix time of the last batch sent successfully.", func(s Stats) float64 {
+		if s.LastSuccess.IsZero() {
+			return 0
+		}
+		return float64(s.LastSuccess.UnixNano()) / 1e9
+	})
+
+	write("# HELP humioctl_ingest_send_duration_seconds Duration of requests.\n# TYPE humioctl_ingest_send_duration_seconds histogram\n")
+	for _, d := range destinations {
+		h := stats[d].SendDuration
+		for i, upperBound := range h.UpperBounds {
+			write("humioctl_ingest_send_duration_seconds_bucket%s %d\n", labels(d, fmt.Sprintf("le=%q", formatFloat(upperBound))), h.Counts[i])
+		}
+		write("humioctl_ingest_send_duration_seconds_sum%s %s\n", labels(d), formatFloat(h.Sum))
+		write("humioctl_ingest_send_duration_seconds_count%s %d\n", labels(d), h.Count)
+	}
+
+	return err
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", f)
+}
+
+type histogram struct {
+	// counts has one bucket per upper bound in sendDurationBuckets plus one for +Inf.
+	counts   [len(sendDurationBuckets) + 1]atomic.Uint64
+	sumNanos atomic.Uint64
+}
+
+// HistogramSnapshot is a cumulative histogram, with Counts[i] observations less than or equal to UpperBounds[i].
+type HistogramSnapshot struct {
+	UpperBounds []float64
+	Counts      []uint64
+	Sum         float64
+	Count       uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(sendDurationBuckets) && seconds > sendDurationBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sumNanos.Add(uint64(d))
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		UpperBounds: append(append([]float64{}, sendDurationBuckets[:]...), math.Inf(1)),
+		Counts:      make([]uint64, len(h.counts)),
+		Sum:         time.Duration(h.sumNanos.Load()).Seconds(),
+	}
+	for i := range h.counts {
+		s.Count += h.counts[i].Load()
+		s.Counts[i] = s.Count
+	}
+	return s
+}
+
+// Quantile estimates the q-quantile, between 0 and 1, by linear interpolation within the bucket it falls in.
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+
+	rank := q * float64(s.Count)
+	lowerBound, lowerCount := 0.0, uint64(0)
+	for i, count := range s.Counts {
+		if float64(count) >= rank {
+			upperBound := s.UpperBounds[i]
+			if math.IsInf(upperBound, 1) || count == lowerCount {
+				return lowerBound
+			}
+			return lowerBound + (upperBound-lowerBound)*(rank-float64(lowerCount))/float64(count-lowerCount)
+		}
+		lowerBound, lowerCount = s.UpperBounds[i], count
+	}
+	return lowerBound
+}
@@ -0,0 +1,54 @@
+package shipper
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	var out bytes.Buffer
+	err := WritePrometheus(&out, map[string]Stats{
+		"b": {EventsReceived: 3, LinesFiltered: 7},
+		"a": {EventsReceived: 5, LinesFiltered: 7, EventsSent: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"# TYPE humioctl_ingest_events_received_total counter\n" +
+			"humioctl_ingest_events_received_total{destination=\"a\"} 5\n" +
+			"humioctl_ingest_events_received_total{destination=\"b\"} 3\n",
+		"# TYPE humioctl_ingest_lines_filtered_total counter\n" +
+			"humioctl_ingest_lines_filtered_total{destination=\"a\"} 7\n",
+		"humioctl_ingest_events_sent_total{destination=\"a\"} 4\n",
+		"humioctl_ingest_send_duration_seconds_count{destination=\"b\"} 0\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected the output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestLogShipperCountsEventsAfterFiltering(t *testing.T) {
+	_, client := newFakeIngest(t)
+	s := newTestShipper(client, "api/v1/ingest/humio-unstructured", nil)
+
+	filter := &RegexFilter{Exclude: []*regexp.Regexp{regexp.MustCompile(`^dropped`)}}
+	multiLine := &MultiLineHandler{LineHandler: s, Regex: regexp.MustCompile(`^\s`), Mode: MultiLineHandlerModeContinuesWith}
+	h := &FilterHandler{LineHandler: multiLine, Filter: filter}
+	for _, line := range []string{"first", "  more", "dropped", "second", "  kept", "dropped"} {
+		h.HandleLine(line)
+	}
+	multiLine.Finish()
+	s.Finish()
+
+	if stats := s.Stats(); stats.EventsReceived != 2 {
+		t.Errorf("expected the two joined events to be received, got %d", stats.EventsReceived)
+	}
+	if filter.Dropped() != 2 {
+		t.Errorf("expected two lines to be filtered, got %d", filter.Dropped())
+	}
+}
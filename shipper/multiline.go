@@ -3,6 +3,8 @@ package shipper
 import (
 	"bytes"
 	"regexp"
+	"sync"
+	"time"
 )
 
 type MultiLineHandlerMode int
@@ -12,32 +14,120 @@ const (
 	MultiLineHandlerModeContinuesWith
 )
 
+// MultiLineHandler joins lines into multi line events. Lines are buffered until
+// the start of the next event is seen, so Finish must be called to emit the
+// last event.
 type MultiLineHandler struct {
 	LineHandler LineHandler
 	Regex       *regexp.Regexp
 	Mode        MultiLineHandlerMode
+	// Negate inverts the match of Regex.
+	Negate bool
+	// FlushTimeout, if set, emits the buffered event when no line has arrived for this long.
+	FlushTimeout time.Duration
+	// MaxLines, if set, emits the buffered event once it has this many lines.
+	MaxLines int
+	// MaxBytes, if set, emits the buffered event before it grows beyond this many bytes.
+	// A single line longer than MaxBytes becomes an event on its own.
+	MaxBytes int
 
-	buf bytes.Buffer
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	lines    int
+	timer    *time.Timer
+	finished bool
 }
 
 func (h *MultiLineHandler) HandleLine(line string) {
-	isMatch := h.Regex.MatchString(line)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	isMatch := h.Regex.MatchString(line) != h.Negate
 
 	switch h.Mode {
 	case MultiLineHandlerModeBeginsWith:
 		if isMatch {
-			fullLine := h.buf.String()
-			h.buf.Reset()
-			h.LineHandler.HandleLine(fullLine)
+			h.flushLocked()
 		}
 
 	case MultiLineHandlerModeContinuesWith:
 		if !isMatch {
-			fullLine := h.buf.String()
-			h.buf.Reset()
-			h.LineHandler.HandleLine(fullLine)
+			h.flushLocked()
 		}
 	}
+
+	if h.MaxBytes > 0 && h.lines > 0 && h.buf.Len()+1+len(line) > h.MaxBytes {
+		h.flushLocked()
+	}
+
+	if h.lines > 0 {
+		h.buf.WriteString("\n")
+	}
 	h.buf.WriteString(line)
-	h.buf.WriteString("\n")
+	h.lines++
+
+	if (h.MaxLines > 0 && h.lines >= h.MaxLines) || (h.MaxBytes > 0 && h.buf.Len() >= h.MaxBytes) {
+		h.flushLocked()
+	}
+
+	h.resetTimerLocked()
+}
+
+// Buffered returns the number of lines of the buffered event.
+func (h *MultiLineHandler) Buffered() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.lines
+}
+
+// Finish emits the buffered event, if any, and stops the flush timer.
+func (h *MultiLineHandler) Finish() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.finished = true
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	h.flushLocked()
+}
+
+func (h *MultiLineHandler) flushLocked() {
+	if h.lines == 0 {
+		return
+	}
+
+	fullLine := h.buf.String()
+	h.buf.Reset()
+	h.lines = 0
+	h.LineHandler.HandleLine(fullLine)
+}
+
+func (h *MultiLineHandler) resetTimerLocked() {
+	if h.FlushTimeout <= 0 || h.finished {
+		return
+	}
+
+	if h.lines == 0 {
+		if h.timer != nil {
+			h.timer.Stop()
+		}
+		return
+	}
+
+	if h.timer == nil {
+		h.timer = time.AfterFunc(h.FlushTimeout, h.flushOnTimeout)
+	} else {
+		h.timer.Reset(h.FlushTimeout)
+	}
+}
+
+func (h *MultiLineHandler) flushOnTimeout() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.finished {
+		h.flushLocked()
+	}
 }
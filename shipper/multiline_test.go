@@ -0,0 +1,133 @@
+package shipper
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lineCollector records the lines it is given.
+type lineCollector struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *lineCollector) HandleLine(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, line)
+}
+
+func (c *lineCollector) collected() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.lines...)
+}
+
+func TestMultiLineHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler *MultiLineHandler
+		lines   []string
+		want    []string
+	}{
+		{
+			name:    "begins with",
+			handler: &MultiLineHandler{Regex: regexp.MustCompile(`^\d`), Mode: MultiLineHandlerModeBeginsWith},
+			lines:   []string{"1 first", "  at a", "  at b", "2 second", "3 third", "  at c"},
+			want:    []string{"1 first\n  at a\n  at b", "2 second", "3 third\n  at c"},
+		},
+		{
+			name:    "continues with",
+			handler: &MultiLineHandler{Regex: regexp.MustCompile(`^\s`), Mode: MultiLineHandlerModeContinuesWith},
+			lines:   []string{"first", "  at a", "second", "  at b"},
+			want:    []string{"first\n  at a", "second\n  at b"},
+		},
+		{
+			name:    "negate",
+			handler: &MultiLineHandler{Regex: regexp.MustCompile(`^\s`), Mode: MultiLineHandlerModeBeginsWith, Negate: true},
+			lines:   []string{"first", "  at a", "second", "  at b"},
+			want:    []string{"first\n  at a", "second\n  at b"},
+		},
+		{
+			name:    "max lines",
+			handler: &MultiLineHandler{Regex: regexp.MustCompile(`^\d`), Mode: MultiLineHandlerModeBeginsWith, MaxLines: 2},
+			lines:   []string{"1 first", "  at a", "  at b", "  at c", "  at d", "2 second"},
+			want:    []string{"1 first\n  at a", "  at b\n  at c", "  at d", "2 second"},
+		},
+		{
+			name:    "max bytes",
+			handler: &MultiLineHandler{Regex: regexp.MustCompile(`^\d`), Mode: MultiLineHandlerModeBeginsWith, MaxBytes: 10},
+			lines:   []string{"1 first", "  a", "  b", "2 a line longer than the limit", "  c"},
+			want:    []string{"1 first", "  a\n  b", "2 a line longer than the limit", "  c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &lineCollector{}
+			tt.handler.LineHandler = collector
+			for _, line := range tt.lines {
+				tt.handler.HandleLine(line)
+			}
+			tt.handler.Finish()
+
+			if got := collector.collected(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMultiLineHandlerFinish(t *testing.T) {
+	collector := &lineCollector{}
+	handler := &MultiLineHandler{LineHandler: collector, Regex: regexp.MustCompile(`^\d`)}
+
+	handler.HandleLine("1 first")
+	handler.HandleLine("  at a")
+	if got := collector.collected(); len(got) != 0 {
+		t.Fatalf("expected the event to be buffered until the next one starts, got %q", got)
+	}
+	if n := handler.Buffered(); n != 2 {
+		t.Errorf("expected 2 buffered lines, got %d", n)
+	}
+
+	handler.Finish()
+	handler.Finish()
+	if got := collector.collected(); !reflect.DeepEqual(got, []string{"1 first\n  at a"}) {
+		t.Errorf("expected the buffered event once on finish, got %q", got)
+	}
+	if n := handler.Buffered(); n != 0 {
+		t.Errorf("expected no buffered lines after finish, got %d", n)
+	}
+}
+
+func TestMultiLineHandlerFlushTimeout(t *testing.T) {
+	collector := &lineCollector{}
+	handler := &MultiLineHandler{
+		LineHandler:  collector,
+		Regex:        regexp.MustCompile(`^\d`),
+		FlushTimeout: 20 * time.Millisecond,
+	}
+
+	handler.HandleLine("1 first")
+	handler.HandleLine("  at a")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(collector.collected()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := collector.collected(); !reflect.DeepEqual(got, []string{"1 first\n  at a"}) {
+		t.Fatalf("expected the event to be sent after the flush timeout, got %q", got)
+	}
+
+	handler.HandleLine("2 second")
+	handler.Finish()
+	got := collector.collected()
+	if len(got) != 2 || strings.HasSuffix(got[1], "\n") || got[1] != "2 second" {
+		t.Errorf("expected the second event on finish, got %q", got)
+	}
+}
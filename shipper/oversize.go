@@ -0,0 +1,146 @@
+package shipper
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// OversizePolicy decides what happens to lines longer than the max line size.
+type OversizePolicy int
+
+const (
+	// OversizeTruncate sends the start of the line, with a @truncated field.
+	OversizeTruncate OversizePolicy = iota
+	// OversizeSplit sends the line in chunks of the max size, with a @chunk_id field shared by the
+	// chunks of a line and a @chunk field with the index of the chunk.
+	OversizeSplit
+	// OversizeDrop drops the line.
+	OversizeDrop
+)
+
+func ParseOversizePolicy(s string) (OversizePolicy, error) {
+	switch s {
+	case "truncate":
+		return OversizeTruncate, nil
+	case "split":
+		return OversizeSplit, nil
+	case "drop":
+		return OversizeDrop, nil
+	default:
+		return 0, fmt.Errorf("unknown oversize policy %q, expected 'truncate', 'split' or 'drop'", s)
+	}
+}
+
+// OversizeStats counts the lines an OversizePolicy was applied to. It can be shared by several LineBuffers.
+type OversizeStats struct {
+	Truncated atomic.Uint64
+	Split     atomic.Uint64
+	Dropped   atomic.Uint64
+}
+
+// LineBuffer assembles lines from chunks of input and applies Policy to the
+// lines longer than MaxSize bytes, so the memory used for a line is bounded
+// by MaxSize.
+type LineBuffer struct {
+	MaxSize int
+	Policy  OversizePolicy
+	// Handle receives each line, with the fields marking truncated lines and chunks of split lines.
+	Handle func(line string, fields map[string]string)
+	// Stats, if set, counts the lines Policy was applied to.
+	Stats *OversizeStats
+
+	buf      []byte
+	started  bool
+	oversize bool
+	chunkID  string
+	chunks   int
+}
+
+// Write adds p, which must not contain newlines, to the current line.
+func (b *LineBuffer) Write(p []byte) {
+	b.started = true
+
+	for len(p) > 0 {
+		if b.oversize && b.Policy != OversizeSplit {
+			return
+		}
+
+		room := b.MaxSize - len(b.buf)
+		if len(p) <= room {
+			b.buf = append(b.buf, p...)
+			return
+		}
+
+		// Cut at the start of a rune, so multi-byte characters are not split, unless the rune is too long to fit at all.
+		cut := room
+		for cut > 0 && !utf8.RuneStart(p[cut]) {
+			cut--
+		}
+		if cut == 0 && len(b.buf) == 0 {
+			cut = room
+		}
+
+		b.oversize = true
+		switch b.Policy {
+		case OversizeTruncate:
+			b.buf = append(b.buf, p[:cut]...)
+		case OversizeDrop:
+			b.buf = b.buf[:0]
+		case OversizeSplit:
+			b.buf = append(b.buf, p[:cut]...)
+			b.emitChunk()
+			p = p[cut:]
+		}
+	}
+}
+
+// EndLine hands over the current line, if any has been started.
+func (b *LineBuffer) EndLine() {
+	if !b.started {
+		return
+	}
+
+	line := string(b.buf)
+	switch {
+	case !b.oversize:
+		b.Handle(line, nil)
+	case b.Policy == OversizeTruncate:
+		if b.Stats != nil {
+			b.Stats.Truncated.Add(1)
+		}
+		b.Handle(line, map[string]string{"@truncated": "true"})
+	case b.Policy == OversizeDrop:
+		if b.Stats != nil {
+			b.Stats.Dropped.Add(1)
+		}
+	case b.Policy == OversizeSplit:
+		if b.Stats != nil {
+			b.Stats.Split.Add(1)
+		}
+		if len(b.buf) > 0 {
+			b.emitChunk()
+		}
+	}
+
+	b.buf = b.buf[:0]
+	b.started, b.oversize, b.chunkID, b.chunks = false, false, "", 0
+}
+
+func (b *LineBuffer) emitChunk() {
+	if b.chunkID == "" {
+		b.chunkID = newChunkID()
+	}
+	b.Handle(string(b.buf), map[string]string{"@chunk_id": b.chunkID, "@chunk": strconv.Itoa(b.chunks)})
+	b.chunks++
+	b.buf = b.buf[:0]
+}
+
+func newChunkID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
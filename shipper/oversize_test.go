@@ -0,0 +1,109 @@
+package shipper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLineBuffer(t *testing.T) {
+	type line struct {
+		text   string
+		fields map[string]string
+	}
+
+	tests := []struct {
+		name   string
+		policy OversizePolicy
+		writes []string
+		want   []line
+	}{
+		{
+			name:   "short line",
+			policy: OversizeTruncate,
+			writes: []string{"ab", "cd"},
+			want:   []line{{"abcd", nil}},
+		},
+		{
+			name:   "truncate",
+			policy: OversizeTruncate,
+			writes: []string{"abc", "defgh"},
+			want:   []line{{"abcde", map[string]string{"@truncated": "true"}}},
+		},
+		{
+			name:   "truncate before a multi-byte rune",
+			policy: OversizeTruncate,
+			writes: []string{"abcd", "é"},
+			want:   []line{{"abcd", map[string]string{"@truncated": "true"}}},
+		},
+		{
+			name:   "split",
+			policy: OversizeSplit,
+			writes: []string{"abcdefghijkl"},
+			want: []line{
+				{"abcde", map[string]string{"@chunk": "0"}},
+				{"fghij", map[string]string{"@chunk": "1"}},
+				{"kl", map[string]string{"@chunk": "2"}},
+			},
+		},
+		{
+			name:   "split before a multi-byte rune",
+			policy: OversizeSplit,
+			writes: []string{"abc", "€def"},
+			want: []line{
+				{"abc", map[string]string{"@chunk": "0"}},
+				{"€de", map[string]string{"@chunk": "1"}},
+				{"f", map[string]string{"@chunk": "2"}},
+			},
+		},
+		{
+			name:   "drop",
+			policy: OversizeDrop,
+			writes: []string{"abc", "def"},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []line
+			var chunkIDs []string
+			stats := &OversizeStats{}
+			b := &LineBuffer{MaxSize: 5, Policy: tt.policy, Stats: stats, Handle: func(text string, fields map[string]string) {
+				if id, ok := fields["@chunk_id"]; ok {
+					chunkIDs = append(chunkIDs, id)
+					delete(fields, "@chunk_id")
+				}
+				got = append(got, line{text, fields})
+			}}
+
+			for _, w := range tt.writes {
+				b.Write([]byte(w))
+			}
+			b.EndLine()
+			b.Write([]byte("next"))
+			b.EndLine()
+
+			want := append(tt.want, line{"next", nil})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+			for _, id := range chunkIDs {
+				if id == "" || id != chunkIDs[0] {
+					t.Errorf("expected the chunks to share a chunk id, got %q", chunkIDs)
+				}
+			}
+
+			applied := stats.Truncated.Load() + stats.Split.Load() + stats.Dropped.Load()
+			if oversize := len(tt.want) != 1 || tt.want[0].fields != nil; oversize != (applied == 1) {
+				t.Errorf("expected the policy to be counted once for an oversize line only, got %d", applied)
+			}
+		})
+	}
+}
+
+func TestLineBufferIgnoresUnstartedLines(t *testing.T) {
+	b := &LineBuffer{MaxSize: 5, Handle: func(text string, fields map[string]string) {
+		t.Errorf("expected no line, got %q", text)
+	}}
+	b.EndLine()
+}
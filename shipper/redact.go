@@ -0,0 +1,171 @@
+package shipper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sort"
+)
+
+// RedactionPattern is a pattern of sensitive data. If Regex has capture
+// groups only the groups are redacted, otherwise the whole match is.
+type RedactionPattern struct {
+	Regex *regexp.Regexp
+	// Spans, if set, returns the parts of a match that are sensitive data, as
+	// start and end offsets into it. A match with no such parts is left as is.
+	Spans func(match string) [][2]int
+}
+
+// BuiltinRedactionPatterns are the patterns of well-known kinds of sensitive data, by name.
+var BuiltinRedactionPatterns = map[string]RedactionPattern{
+	"email": {
+		Regex: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	"credit-card": {
+		Regex: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		Spans: luhnSpans,
+	},
+	"jwt": {
+		Regex: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	},
+	"aws-access-key": {
+		Regex: regexp.MustCompile(`\b(?:AKIA|ASIA|AROA|AIDA)[A-Z0-9]{16}\b`),
+	},
+	"aws-secret-key": {
+		Regex: regexp.MustCompile(`(?i)aws.{0,20}?(?:secret|key).{0,20}?[=:]\s*["']?([A-Za-z0-9/+]{40})\b`),
+	},
+}
+
+// BuiltinRedactionPatternNames returns the names of BuiltinRedactionPatterns in sorted order.
+func BuiltinRedactionPatternNames() []string {
+	names := make([]string, 0, len(BuiltinRedactionPatterns))
+	for name := range BuiltinRedactionPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RedactionHandler replaces sensitive data in each line before passing it on.
+type RedactionHandler struct {
+	LineHandler LineHandler
+	Patterns    []RedactionPattern
+	// Hash replaces sensitive data with a hash of it instead of a mask, so equal
+	// values can still be correlated. The hash is keyed with HashKey, if set,
+	// so it cannot be reversed by hashing guesses without knowing the key.
+	Hash    bool
+	HashKey []byte
+}
+
+func (h *RedactionHandler) HandleLine(line string) {
+	h.LineHandler.HandleLine(h.Redact(line))
+}
+
+// Redact returns s with the sensitive data matched by Patterns masked or hashed.
+func (h *RedactionHandler) Redact(s string) string {
+	for _, p := range h.Patterns {
+		s = h.redact(s, p)
+	}
+	return s
+}
+
+func (h *RedactionHandler) redact(line string, p RedactionPattern) string {
+	matches := p.Regex.FindAllStringSubmatchIndex(line, -1)
+	if matches == nil {
+		return line
+	}
+
+	var out []byte
+	last := 0
+	for _, m := range matches {
+		// Redact the capture groups if there are any, otherwise the whole match.
+		spans := m[2:]
+		if len(spans) == 0 {
+			spans = m[:2]
+		}
+		for i := 0; i < len(spans); i += 2 {
+			start, end := spans[i], spans[i+1]
+			if start < last || start == end {
+				continue
+			}
+			sensitive := [][2]int{{0, end - start}}
+			if p.Spans != nil {
+				sensitive = p.Spans(line[start:end])
+			}
+			for _, span := range sensitive {
+				out = append(out, line[last:start+span[0]]...)
+				out = append(out, h.replacement(line[start+span[0]:start+span[1]])...)
+				last = start + span[1]
+			}
+		}
+	}
+	out = append(out, line[last:]...)
+
+	return string(out)
+}
+
+func (h *RedactionHandler) replacement(value string) string {
+	if !h.Hash {
+		return "[REDACTED]"
+	}
+
+	mac := hmac.New(sha256.New, h.HashKey)
+	mac.Write([]byte(value))
+	return "[REDACTED:" + hex.EncodeToString(mac.Sum(nil)[:12]) + "]"
+}
+
+// luhnSpans returns the span of match if its digits pass the Luhn checksum used by credit card numbers. Otherwise,
+// as a card number may be next to other groups of digits, it returns the runs of 13 to 19 of its digits that pass
+// and begin and end at a space or dash. Runs within a group are not tried, as some of them pass by chance.
+func luhnSpans(match string) [][2]int {
+	if luhnValid(match) {
+		return [][2]int{{0, len(match)}}
+	}
+
+	var digits []int
+	for i := 0; i < len(match); i++ {
+		if match[i] >= '0' && match[i] <= '9' {
+			digits = append(digits, i)
+		}
+	}
+	// separated reports whether the digits first to last are not next to other digits of the match.
+	separated := func(first, last int) bool {
+		return (first == 0 || digits[first]-digits[first-1] > 1) && (last == len(digits)-1 || digits[last+1]-digits[last] > 1)
+	}
+
+	var spans [][2]int
+	for first := 0; first+13 <= len(digits); first++ {
+		for n := min(19, len(digits)-first); n >= 13; n-- {
+			last := first + n - 1
+			start, end := digits[first], digits[last]+1
+			if separated(first, last) && luhnValid(match[start:end]) {
+				spans = append(spans, [2]int{start, end})
+				first = last
+				break
+			}
+		}
+	}
+	return spans
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum used by credit card numbers.
+func luhnValid(s string) bool {
+	sum, digits := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
@@ -0,0 +1,123 @@
+package shipper
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy decides whether and when a batch that failed to send is sent again.
+// The number of attempts is limited by LogShipper.MaxAttemptsPerBatch.
+type RetryPolicy interface {
+	// Backoff returns how long to wait before retry number retry, starting at 1, after err.
+	// It returns false if the batch should not be retried.
+	Backoff(retry int, err error) (time.Duration, bool)
+}
+
+// ExponentialBackoff is the default RetryPolicy. It doubles the delay for
+// each retry up to MaxDelay, adds random jitter, waits as long as the server
+// asks for in Retry-After and does not retry permanent errors.
+type ExponentialBackoff struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction of the delay that is randomized, between 0 and 1.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the RetryPolicy used when LogShipper.RetryPolicy is not set.
+func DefaultRetryPolicy() *ExponentialBackoff {
+	return &ExponentialBackoff{
+		BaseDelay: 500 * time.Millisecond,
+		MaxDelay:  30 * time.Second,
+		Jitter:    0.2,
+	}
+}
+
+func (b *ExponentialBackoff) Backoff(retry int, err error) (time.Duration, bool) {
+	if IsPermanent(err) {
+		return 0, false
+	}
+
+	var sendErr *SendError
+	if errors.As(err, &sendErr) && sendErr.RetryAfter > 0 {
+		return sendErr.RetryAfter, true
+	}
+
+	delay := float64(b.BaseDelay) * math.Pow(2, float64(retry-1))
+	if b.MaxDelay > 0 && delay > float64(b.MaxDelay) {
+		delay = float64(b.MaxDelay)
+	}
+	if b.Jitter > 0 {
+		delay -= delay * b.Jitter * rand.Float64() // #nosec G404
+	}
+
+	return time.Duration(delay), true
+}
+
+// SendError is returned when Humio responds to a batch with an error status.
+type SendError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	// RetryAfter is the delay requested by the Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *SendError) Error() string {
+	msg := fmt.Sprintf("bad response while sending events (status='%s'): %s", e.Status, strings.TrimSpace(e.Body))
+
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		msg += " - the token was not accepted, check that the API or ingest token is valid"
+	case http.StatusForbidden:
+		msg += " - the token is not allowed to ingest into this repository"
+	case http.StatusNotFound:
+		msg += " - the repository or ingest endpoint does not exist"
+	case http.StatusBadRequest:
+		msg += " - the request was rejected, check that the parser exists and the data is in the expected format"
+	case http.StatusRequestEntityTooLarge:
+		msg += " - the batch is too large, use a smaller batch size"
+	}
+
+	return msg
+}
+
+// IsPermanent reports whether err is an error response that will not succeed if the batch is sent again.
+func IsPermanent(err error) bool {
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		return false
+	}
+
+	switch sendErr.StatusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusRequestEntityTooLarge:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseRetryAfter parses the Retry-After header of 429 and 503 responses, given either in seconds or as a date.
+func parseRetryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
@@ -0,0 +1,119 @@
+package shipper
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := &ExponentialBackoff{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	transient := &SendError{StatusCode: http.StatusInternalServerError}
+
+	tests := []struct {
+		retry int
+		err   error
+		want  time.Duration
+		ok    bool
+	}{
+		{1, transient, 100 * time.Millisecond, true},
+		{2, transient, 200 * time.Millisecond, true},
+		{4, transient, 800 * time.Millisecond, true},
+		{5, transient, time.Second, true},
+		{50, transient, time.Second, true},
+		{1, errors.New("connection refused"), 100 * time.Millisecond, true},
+		{3, &SendError{StatusCode: http.StatusTooManyRequests, RetryAfter: 5 * time.Second}, 5 * time.Second, true},
+		{1, &SendError{StatusCode: http.StatusBadRequest}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d %v", tt.retry, tt.err), func(t *testing.T) {
+			got, ok := b.Backoff(tt.retry, tt.err)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("expected %v, %t, got %v, %t", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestExponentialBackoffJitter(t *testing.T) {
+	b := &ExponentialBackoff{BaseDelay: time.Second, MaxDelay: 4 * time.Second, Jitter: 0.5}
+	err := &SendError{StatusCode: http.StatusServiceUnavailable}
+
+	for retry := 1; retry <= 5; retry++ {
+		ceiling := min(time.Second<<(retry-1), 4*time.Second)
+		for range 100 {
+			got, ok := b.Backoff(retry, err)
+			if !ok || got > ceiling || got < ceiling/2 {
+				t.Fatalf("expected retry %d to wait between %v and %v, got %v", retry, ceiling/2, ceiling, got)
+			}
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&SendError{StatusCode: http.StatusBadRequest}, true},
+		{&SendError{StatusCode: http.StatusUnauthorized}, true},
+		{&SendError{StatusCode: http.StatusForbidden}, true},
+		{&SendError{StatusCode: http.StatusNotFound}, true},
+		{&SendError{StatusCode: http.StatusRequestEntityTooLarge}, true},
+		{fmt.Errorf("batch 3: %w", &SendError{StatusCode: http.StatusForbidden}), true},
+		{&SendError{StatusCode: http.StatusRequestTimeout}, false},
+		{&SendError{StatusCode: http.StatusTooManyRequests}, false},
+		{&SendError{StatusCode: http.StatusInternalServerError}, false},
+		{&SendError{StatusCode: http.StatusBadGateway}, false},
+		{&SendError{StatusCode: http.StatusServiceUnavailable}, false},
+		{errors.New("connection reset by peer"), false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.err), func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.want {
+				t.Errorf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		status int
+		value  string
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"seconds", http.StatusTooManyRequests, "7", 7 * time.Second, 7 * time.Second},
+		{"seconds on 503", http.StatusServiceUnavailable, "120", 2 * time.Minute, 2 * time.Minute},
+		{"date", http.StatusServiceUnavailable, date, 58 * time.Second, time.Minute},
+		{"missing", http.StatusTooManyRequests, "", 0, 0},
+		{"zero", http.StatusTooManyRequests, "0", 0, 0},
+		{"negative", http.StatusTooManyRequests, "-5", 0, 0},
+		{"invalid", http.StatusTooManyRequests, "soon", 0, 0},
+		{"ignored on 500", http.StatusInternalServerError, "7", 0, 0},
+		{"ignored on 502", http.StatusBadGateway, date, 0, 0},
+		{"ignored on 400", http.StatusBadRequest, "7", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Status: strconv.Itoa(tt.status), Header: http.Header{}}
+			if tt.value != "" {
+				resp.Header.Set("Retry-After", tt.value)
+			}
+			if got := parseRetryAfter(resp); got < tt.min || got > tt.max {
+				t.Errorf("expected between %v and %v, got %v", tt.min, tt.max, got)
+			}
+		})
+	}
+}
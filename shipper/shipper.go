@@ -1,11 +1,15 @@
 package shipper
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"math"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/humio/cli/internal/api"
@@ -25,10 +29,28 @@ const (
 	ErrorBehaviourPanic
 )
 
+// IngestFormat selects the payload format the shipper sends batches in.
+type IngestFormat int
+
+const (
+	// IngestFormatUnstructured sends lines as messages to be parsed by Humio, e.g. to ingest-messages or humio-unstructured.
+	IngestFormatUnstructured IngestFormat = iota
+	// IngestFormatStructured sends events with attributes to humio-structured. Fields are added as attributes.
+	IngestFormatStructured
+	// IngestFormatHEC sends events in the Splunk HTTP Event Collector format to the hec endpoint. Fields are added as HEC fields.
+	IngestFormatHEC
+)
+
 type LineHandler interface {
 	HandleLine(line string)
 }
 
+// Handler receives both plain lines and structured events.
+type Handler interface {
+	LineHandler
+	EventHandler
+}
+
 type LogShipper struct {
 	APIClient           *api.Client
 	URL                 string
@@ -36,17 +58,112 @@ type LogShipper struct {
 	ParserName          string
 	MaxAttemptsPerBatch int
 	ErrorBehaviour      ErrorBehaviour
+	Format              IngestFormat
+	HECDefaults         HECMetadata
+	Compression         Compression
 	BatchSizeLines      int
 	BatchSizeBytes      int
 	BatchTimeout        time.Duration
 	Logger              func(format string, v ...interface{})
-
-	events          chan string
+	// RetryPolicy decides when failed batches are retried. Defaults to DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+	// DeadLetter, if set, receives batches that could not be sent and are not kept in the Spool.
+	DeadLetter *DeadLetterFile
+	// Bundle, if set, receives the batches instead of them being sent.
+	Bundle *BundleWriter
+	// Concurrency is the number of batches sent at the same time. Defaults to 1.
+	Concurrency int
+	// QueueSize is the number of complete batches waiting for a sender before
+	// HandleLine blocks. Defaults to Concurrency.
+	QueueSize int
+	// OrderPerSource keeps the events of each source, as identified by their
+	// fields, in order when Concurrency is above 1.
+	OrderPerSource bool
+	// Spool, if set, receives every line before it is batched. Lines are
+	// acknowledged in the spool once they have been sent, and lines left in
+	// the spool by a previous run are sent first when the shipper starts.
+	Spool *Spool
+
+	events          chan event
 	finishedSending chan struct{}
+
+	metrics     metrics
+	checkpoints checkpoints
+}
+
+// event is a line or structured event waiting to be sent along with its own
+// fields, the spool segment it was written to, if any, and its checkpoint epoch.
+type event struct {
+	line         string
+	structured   *Event
+	fields       map[string]string
+	spoolSegment uint64
+	epoch        uint64
+}
+
+func (e event) size() int {
+	if e.structured != nil {
+		return e.structured.size()
+	}
+	return len(e.line)
+}
+
+// spoolRecord is the on-disk representation of an event in the spool.
+type spoolRecord struct {
+	Line   string            `json:"line,omitempty"`
+	Event  *Event            `json:"event,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
 }
 
 func (s *LogShipper) HandleLine(line string) {
-	s.events <- line
+	s.handleEvent(event{line: line})
+}
+
+func (s *LogShipper) HandleEvent(e Event) {
+	s.handleEvent(event{structured: &e})
+}
+
+// WithFields returns a Handler that sends lines and events through the shipper
+// with the given fields added to each of them. The fields take precedence over Fields.
+func (s *LogShipper) WithFields(fields map[string]string) Handler {
+	return &fieldsHandler{shipper: s, fields: fields}
+}
+
+type fieldsHandler struct {
+	shipper *LogShipper
+	fields  map[string]string
+}
+
+func (h *fieldsHandler) HandleLine(line string) {
+	h.shipper.handleEvent(event{line: line, fields: h.fields})
+}
+
+func (h *fieldsHandler) HandleEvent(e Event) {
+	h.shipper.handleEvent(event{structured: &e, fields: h.fields})
+}
+
+// Checkpoint calls done once the lines and events handled so far are settled:
+// sent, written to the Spool, DeadLetter or Bundle, or dropped after all
+// attempts. Callbacks are called in the order of the checkpoints.
+func (s *LogShipper) Checkpoint(done func()) {
+	s.checkpoints.checkpoint(done)
+}
+
+func (s *LogShipper) handleEvent(e event) {
+	s.metrics.eventsReceived.Add(1)
+	e.epoch = s.checkpoints.handle()
+
+	if s.Spool != nil {
+		record, err := json.Marshal(spoolRecord{Line: e.line, Event: e.structured, Fields: e.fields})
+		if err == nil {
+			e.spoolSegment, err = s.Spool.Append(record)
+		}
+		if err != nil && s.Logger != nil {
+			s.Logger("Error writing event to spool, sending it without spooling: %v", err)
+		}
+	}
+
+	s.events <- e
 }
 
 func (s *LogShipper) Finish() {
@@ -55,139 +172,421 @@ func (s *LogShipper) Finish() {
 }
 
 func (s *LogShipper) Start() {
-	s.events = make(chan string, s.BatchSizeLines)
+	s.events = make(chan event, s.BatchSizeLines)
 	s.finishedSending = make(chan struct{})
 
-	go func() {
-		defer func() { close(s.finishedSending) }()
-		var batch []string
-		if s.BatchSizeLines != 0 {
-			batch = make([]string, 0, s.BatchSizeLines)
-		}
+	senders := s.Concurrency
+	if senders < 1 {
+		senders = 1
+	}
+	queueSize := s.QueueSize
+	if queueSize < 1 {
+		queueSize = senders
+	}
 
-		for {
-			bytes := 0
+	// Without ordering per source all senders share one queue. With it, each
+	// sender has its own queue and all events from a source go to the same one.
+	queues := make([]chan []event, 1)
+	if s.OrderPerSource {
+		queues = make([]chan []event, senders)
+	}
+	for i := range queues {
+		queues[i] = make(chan []event, max(queueSize/len(queues), 1))
+	}
 
-			e, more := <-s.events
-			if !more {
-				break
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		queue := queues[i%len(queues)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range queue {
+				s.sendEvents(batch)
 			}
+		}()
+	}
 
-			batch = append(batch, e)
-			bytes += len(e)
+	go func() {
+		s.batchEvents(queues)
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+		close(s.finishedSending)
+	}()
 
-			timeout := time.After(s.BatchTimeout)
+	if s.Spool != nil {
+		s.replaySpool()
+	}
+}
+
+type pendingBatch struct {
+	events   []event
+	bytes    int
+	deadline time.Time
+}
+
+// batchEvents collects events into batches and puts them on the queues until
+// the events channel is closed. A batch is queued when it reaches
+// BatchSizeLines or BatchSizeBytes, or BatchTimeout after its first event.
+// Putting a batch on a full queue blocks, which in turn blocks HandleLine once
+// the events channel is full.
+func (s *LogShipper) batchEvents(queues []chan []event) {
+	pending := make([]pendingBatch, len(queues))
+
+	flush := func(i int) {
+		if len(pending[i].events) > 0 {
+			queues[i] <- pending[i].events
+			pending[i] = pendingBatch{}
+		}
+	}
 
-			if s.BatchSizeBytes > 0 && bytes > s.BatchSizeBytes {
-				goto send
+	var timeout <-chan time.Time
+	for {
+		select {
+		case e, more := <-s.events:
+			if !more {
+				for i := range pending {
+					flush(i)
+				}
+				return
 			}
 
-		loop:
-			for {
-				select {
-				case e, more := <-s.events:
-					if !more {
-						break loop
-					}
-					batch = append(batch, e)
-					bytes += len(e)
-					if len(batch) >= s.BatchSizeLines || (s.BatchSizeBytes > 0 && bytes > s.BatchSizeBytes) {
-						break loop
-					}
-				case <-timeout:
-					break loop
+			i := e.partition(len(queues))
+			b := &pending[i]
+			if len(b.events) == 0 {
+				b.deadline = time.Now().Add(s.BatchTimeout)
+				if s.BatchSizeLines > 0 {
+					b.events = make([]event, 0, s.BatchSizeLines)
 				}
 			}
+			b.events = append(b.events, e)
+			b.bytes += e.size()
+			if len(b.events) >= s.BatchSizeLines || (s.BatchSizeBytes > 0 && b.bytes > s.BatchSizeBytes) {
+				flush(i)
+			}
+		case <-timeout:
+		}
 
-		send:
-			s.sendBatch(batch)
+		now := time.Now()
+		var next time.Time
+		for i := range pending {
+			if len(pending[i].events) == 0 {
+				continue
+			}
+			if !pending[i].deadline.After(now) {
+				flush(i)
+			} else if next.IsZero() || pending[i].deadline.Before(next) {
+				next = pending[i].deadline
+			}
+		}
+		timeout = nil
+		if !next.IsZero() {
+			timeout = time.After(time.Until(next))
+		}
+	}
+}
 
-			batch = batch[:0]
-			bytes = 0
+// partition returns which of n partitions the source of the event belongs to.
+// The source is identified by the fields and tags of the event.
+func (e event) partition(n int) int {
+	if n == 1 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	writeSorted := func(m map[string]string) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
 		}
-		if len(batch) > 0 {
-			s.sendBatch(batch)
+		sort.Strings(keys)
+		for _, k := range keys {
+			_, _ = h.Write([]byte(k))
+			_, _ = h.Write([]byte{0})
+			_, _ = h.Write([]byte(m[k]))
+			_, _ = h.Write([]byte{0})
 		}
-	}()
+	}
+	writeSorted(e.fields)
+	if e.structured != nil {
+		writeSorted(e.structured.Tags)
+	}
+
+	return int(h.Sum32() % uint32(n))
 }
 
-func (s *LogShipper) sendBatch(messages []string) {
-	ship := func() error {
-		var eg errgroup.Group
+// replaySpool queues the lines left in the spool by a previous run. It blocks until all of them have been queued.
+func (s *LogShipper) replaySpool() {
+	replayed := 0
+	err := s.Spool.Replay(func(record []byte, segment uint64) {
+		var r spoolRecord
+		if err := json.Unmarshal(record, &r); err != nil {
+			if s.Logger != nil {
+				s.Logger("Skipping unreadable event in spool: %v", err)
+			}
+			s.Spool.Ack(segment, 1)
+			return
+		}
+		s.events <- event{line: r.Line, structured: r.Event, fields: r.Fields, spoolSegment: segment}
+		replayed++
+	})
 
-		pr, pw := io.Pipe()
+	if s.Logger != nil {
+		if err != nil {
+			s.Logger("Error replaying spool: %v", err)
+		}
+		if replayed > 0 {
+			s.Logger("Replayed %d events from spool %s", replayed, s.Spool.Dir())
+		}
+	}
+}
 
-		jsonBody := []eventList{{
-			Type:     s.ParserName,
-			Fields:   s.Fields,
-			Messages: messages,
-		}}
+func (s *LogShipper) sendEvents(batch []event) {
+	var encode func(w io.Writer) error
+	switch s.Format {
+	case IngestFormatStructured:
+		body := s.structuredEventLists(batch)
+		encode = func(w io.Writer) error { return json.NewEncoder(w).Encode(body) }
+	case IngestFormatHEC:
+		encode = func(w io.Writer) error { return s.writeHECEvents(w, batch) }
+	default:
+		body := s.eventLists(batch)
+		encode = func(w io.Writer) error { return json.NewEncoder(w).Encode(body) }
+	}
 
-		eg.Go(func() error {
-			defer pw.Close()
-			return json.NewEncoder(pw).Encode(jsonBody)
-		})
+	err := s.sendBatch(encode, len(batch))
 
-		var resp *http.Response
+	// Dropped events are settled too, as they have been counted and logged, so later checkpoints are not held back.
+	settled := map[uint64]int{}
+	for _, e := range batch {
+		settled[e.epoch]++
+	}
+	s.checkpoints.settle(settled)
 
-		eg.Go(func() error {
-			var err error
-			resp, err = s.APIClient.HTTPRequest(http.MethodPost, s.URL, pr)
-			return err
-		})
+	if err != nil || s.Spool == nil {
+		return
+	}
 
-		err := eg.Wait()
+	acks := map[uint64]int{}
+	for _, e := range batch {
+		if e.spoolSegment != 0 {
+			acks[e.spoolSegment]++
+		}
+	}
+	for segment, count := range acks {
+		s.Spool.Ack(segment, count)
+	}
+}
 
-		if err != nil {
-			return err
+// eventLists groups consecutive events with the same fields into one eventList each.
+func (s *LogShipper) eventLists(batch []event) []eventList {
+	var lists []eventList
+	var listFields map[string]string
+
+	for i, e := range batch {
+		if i == 0 || !equalFields(e.fields, listFields) {
+			listFields = e.fields
+			lists = append(lists, eventList{
+				Type:   s.ParserName,
+				Fields: mergeFields(s.Fields, e.fields),
+			})
 		}
+		l := &lists[len(lists)-1]
+		l.Messages = append(l.Messages, e.message())
+	}
 
-		if resp.StatusCode >= 400 {
-			responseData, err := io.ReadAll(resp.Body)
+	return lists
+}
+
+func equalFields(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
 
-			if err != nil {
-				return fmt.Errorf("error reading http response body: %w", err)
+func mergeFields(base, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(extra))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
+
+func (s *LogShipper) sendBatch(encode func(w io.Writer) error, events int) error {
+	var err error
+	if s.Bundle != nil {
+		err = s.writeBundle(encode, events)
+	} else {
+		err = s.sendWithRetries(s.URL, encode)
+	}
+
+	if err == nil {
+		s.metrics.eventsSent.Add(uint64(events))
+		s.metrics.batchesSent.Add(1)
+		s.metrics.lastSuccess.Store(time.Now().UnixNano())
+	} else {
+		s.metrics.batchesFailed.Add(1)
+
+		kept := s.Spool != nil && s.ErrorBehaviour == ErrorBehaviourDrop
+		if !kept {
+			s.metrics.eventsDropped.Add(uint64(events))
+		}
+		if s.DeadLetter != nil && !kept {
+			s.writeDeadLetter(encode, events, err)
+		}
+
+		switch s.ErrorBehaviour {
+		case ErrorBehaviourPanic:
+			if s.Logger != nil {
+				s.Logger("Error sending logs to Humio: %v", err)
+			}
+			panic(fmt.Sprintf("Error sending logs to Humio: %v", err))
+		case ErrorBehaviourDrop:
+			if s.Logger != nil {
+				if kept {
+					s.Logger("Error sending logs to Humio, keeping %d events in spool for the next run: %v", events, err)
+				} else {
+					s.Logger("Error sending logs to Humio, dropping %d events: %v", events, err)
+				}
 			}
+		}
+	}
+
+	return err
+}
+
+func (s *LogShipper) writeDeadLetter(encode func(w io.Writer) error, events int, sendErr error) {
+	var body bytes.Buffer
+	err := encode(&body)
+	if err == nil {
+		err = s.DeadLetter.Write(DeadLetterRecord{
+			Time:   time.Now(),
+			URL:    s.URL,
+			Error:  sendErr.Error(),
+			Events: events,
+			Body:   body.String(),
+		})
+	}
+
+	if err == nil {
+		s.metrics.eventsDeadLettered.Add(uint64(events))
+	}
 
-			return fmt.Errorf("bad response while sending events (status='%s'): %s", resp.Status, responseData)
+	if s.Logger != nil {
+		if err != nil {
+			s.Logger("Error writing %d events to dead-letter file %s: %v", events, s.DeadLetter.Path(), err)
 		} else {
-			// discard the response in order to re-use the connection
-			_, _ = io.Copy(io.Discard, resp.Body)
-			_ = resp.Body.Close()
+			s.Logger("Wrote %d events to dead-letter file %s", events, s.DeadLetter.Path())
 		}
+	}
+}
 
-		return nil
+func (s *LogShipper) sendWithRetries(url string, encode func(w io.Writer) error) error {
+	retryPolicy := s.RetryPolicy
+	if retryPolicy == nil {
+		retryPolicy = DefaultRetryPolicy()
 	}
 
 	var err error
 	for i := 0; i < s.MaxAttemptsPerBatch; i++ {
-		if i > 0 {
-			backOff := time.Duration(0.5*math.Pow(2, float64(i-1))*1000) * time.Millisecond
+		err = s.ship(url, encode)
+		if err == nil {
+			break
+		}
+		if i == s.MaxAttemptsPerBatch-1 {
+			break
+		}
+
+		backOff, retry := retryPolicy.Backoff(i+1, err)
+		if !retry {
 			if s.Logger != nil {
-				s.Logger("Backoff for %v...", backOff)
+				s.Logger("Error while sending logs to Humio is permanent, not retrying.")
 			}
-			time.Sleep(backOff)
-		}
-		err = ship()
-		if err == nil {
 			break
 		}
 		if s.Logger != nil {
 			s.Logger("Error while sending logs to Humio. Retrying %d more times. Error message: %v", s.MaxAttemptsPerBatch-i-1, err)
+			s.Logger("Backoff for %v...", backOff)
 		}
+		time.Sleep(backOff)
+		s.metrics.retries.Add(1)
 	}
 
-	if err != nil {
-		switch s.ErrorBehaviour {
-		case ErrorBehaviourPanic:
-			if s.Logger != nil {
-				s.Logger("Error sending logs to Humio: %v", err)
-			}
-			panic(fmt.Sprintf("Error sending logs to Humio: %v", err))
-		case ErrorBehaviourDrop:
-			if s.Logger != nil {
-				s.Logger("Error sending logs to Humio, dropping %d events: %v", len(messages), err)
+	return err
+}
+
+// ship makes a single attempt at sending the request body written by encode to url.
+func (s *LogShipper) ship(url string, encode func(w io.Writer) error) error {
+	var eg errgroup.Group
+
+	pr, pw := io.Pipe()
+
+	eg.Go(func() error {
+		cw, err := s.Compression.newWriter(countingWriter{w: pw, n: &s.metrics.bytesSent})
+		if err == nil {
+			err = encode(countingWriter{w: cw, n: &s.metrics.bytesUncompressed})
+			if closeErr := cw.Close(); err == nil {
+				err = closeErr
 			}
 		}
+		pw.CloseWithError(err)
+		return err
+	})
+
+	headers := map[string]string{"Content-Type": api.JSONContentType}
+	if encoding := s.Compression.contentEncoding(); encoding != "" {
+		headers["Content-Encoding"] = encoding
+	}
+
+	var resp *http.Response
+
+	start := time.Now()
+	eg.Go(func() error {
+		var err error
+		s.metrics.requests.Add(1)
+		resp, err = s.APIClient.HTTPRequestContextWithHeaders(context.Background(), http.MethodPost, url, pr, headers)
+		return err
+	})
+
+	err := eg.Wait()
+	s.metrics.sendDuration.observe(time.Since(start))
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 {
+		responseData, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		if err != nil {
+			return fmt.Errorf("error reading http response body: %w", err)
+		}
+
+		return &SendError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       string(responseData),
+			RetryAfter: parseRetryAfter(resp),
+		}
 	}
+
+	// discard the response in order to re-use the connection
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	return nil
 }
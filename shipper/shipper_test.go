@@ -0,0 +1,121 @@
+package shipper
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/humio/cli/internal/api"
+)
+
+// ingestRequest is a request received by fakeIngest.
+type ingestRequest struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// fakeIngest is an ingest endpoint that records the requests it receives. It answers them with statuses in
+// order, and with 200 once they run out.
+type fakeIngest struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []ingestRequest
+}
+
+// newFakeIngest starts a fakeIngest and returns it along with a client for it.
+func newFakeIngest(t *testing.T, statuses ...int) (*fakeIngest, *api.Client) {
+	t.Helper()
+	f := &fakeIngest{statuses: statuses}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	address, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, api.NewClient(api.Config{Address: address, Token: "token"})
+}
+
+func (f *fakeIngest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, ingestRequest{path: r.URL.Path, header: r.Header.Clone(), body: body})
+	status := http.StatusOK
+	if len(f.statuses) > 0 {
+		status, f.statuses = f.statuses[0], f.statuses[1:]
+	}
+	f.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+func (f *fakeIngest) received() []ingestRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ingestRequest(nil), f.requests...)
+}
+
+// newTestShipper returns a started shipper sending each line in a batch of its own to url, without retries.
+func newTestShipper(client *api.Client, url string, configure func(s *LogShipper)) *LogShipper {
+	s := &LogShipper{
+		APIClient:           client,
+		URL:                 url,
+		MaxAttemptsPerBatch: 1,
+		ErrorBehaviour:      ErrorBehaviourDrop,
+		BatchSizeLines:      1,
+		BatchTimeout:        time.Hour,
+	}
+	if configure != nil {
+		configure(s)
+	}
+	s.Start()
+	return s
+}
+
+func TestLogShipperCheckpointsPastDroppedBatch(t *testing.T) {
+	ingest, client := newFakeIngest(t, http.StatusBadRequest)
+	s := newTestShipper(client, "api/v1/ingest/humio-unstructured", nil)
+
+	var mu sync.Mutex
+	var called []string
+	checkpoint := func(name string) {
+		s.Checkpoint(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			called = append(called, name)
+		})
+	}
+
+	s.HandleLine("dropped")
+	checkpoint("first")
+	for i := 0; i < 100; i++ {
+		s.HandleLine("sent")
+		checkpoint("later")
+	}
+	s.Finish()
+
+	if n := len(ingest.received()); n != 101 {
+		t.Fatalf("expected 101 requests, got %d", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(called) != 101 || called[0] != "first" {
+		t.Errorf("expected all checkpoints to be called back in order, got %d starting with %q", len(called), called[:min(1, len(called))])
+	}
+	if stats := s.Stats(); stats.EventsDropped != 1 || stats.EventsSent != 100 {
+		t.Errorf("expected the dropped event to be counted, got %+v", stats)
+	}
+	if n := len(s.checkpoints.epochs); n != 1 {
+		t.Errorf("expected only the open epoch to be left, got %d epochs", n)
+	}
+}
@@ -0,0 +1,294 @@
+package shipper
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const spoolSegmentSuffix = ".spool"
+
+// DefaultSpoolSegmentSizeBytes is used when OpenSpool is called with a non-positive segment size.
+const DefaultSpoolSegmentSizeBytes = 16 * 1024 * 1024
+
+// DefaultSpoolSyncInterval is the SyncInterval of a spool returned by OpenSpool.
+const DefaultSpoolSyncInterval = time.Second
+
+// Spool is an on-disk write-ahead log of records that have not yet been
+// acknowledged by Humio.
+//
+// Records are appended to segment files in the spool directory. A segment is
+// removed once it has been rotated out and every record in it has been
+// acknowledged. Segments left behind when the process stops are replayed the
+// next time a spool is opened on the same directory, which gives at-least-once
+// delivery: records acknowledged from a segment that still had unacknowledged
+// records will be sent again.
+//
+// Appended records are synced to disk in batches: the current segment is
+// synced at most SyncInterval after the first record appended since the last
+// sync, so a power loss loses at most that much of the records not yet sent.
+//
+// A spool directory must not be shared between processes.
+type Spool struct {
+	// SyncInterval is the max time an appended record is kept before it is synced to disk.
+	// If zero, each record is synced as it is appended. It must not be changed once records are appended.
+	SyncInterval time.Duration
+
+	dir              string
+	segmentSizeBytes int64
+	syncTimer        *time.Timer
+	syncErr          error
+
+	mu       sync.Mutex
+	current  *spoolSegment
+	segments map[uint64]*spoolSegment
+	pending  []uint64
+	nextID   uint64
+}
+
+type spoolSegment struct {
+	id       uint64
+	path     string
+	file     *os.File
+	size     int64
+	appended int
+	acked    int
+	sealed   bool
+}
+
+// OpenSpool opens the spool in dir, creating the directory if needed.
+// Segments found in the directory are kept for Replay.
+func OpenSpool(dir string, segmentSizeBytes int64) (*Spool, error) {
+	if segmentSizeBytes <= 0 {
+		segmentSizeBytes = DefaultSpoolSegmentSizeBytes
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create spool directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read spool directory: %w", err)
+	}
+
+	s := &Spool{
+		SyncInterval:     DefaultSpoolSyncInterval,
+		dir:              dir,
+		segmentSizeBytes: segmentSizeBytes,
+		segments:         map[uint64]*spoolSegment{},
+		nextID:           1,
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spoolSegmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentSuffix), 10, 64)
+		if err != nil || id == 0 {
+			continue
+		}
+		s.segments[id] = &spoolSegment{id: id, path: filepath.Join(dir, name)}
+		s.pending = append(s.pending, id)
+		if id >= s.nextID {
+			s.nextID = id + 1
+		}
+	}
+	sort.Slice(s.pending, func(i, j int) bool { return s.pending[i] < s.pending[j] })
+
+	return s, nil
+}
+
+// Dir returns the directory of the spool.
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+// Replay calls handle for every record in the segments left behind by a previous process, oldest first.
+// The segment id passed to handle must be given to Ack once the record has been delivered.
+// A partially written record at the end of a segment is skipped.
+func (s *Spool) Replay(handle func(record []byte, segment uint64)) error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	for _, id := range pending {
+		s.mu.Lock()
+		seg := s.segments[id]
+		s.mu.Unlock()
+
+		if err := s.replaySegment(seg, handle); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Spool) replaySegment(seg *spoolSegment, handle func(record []byte, segment uint64)) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return fmt.Errorf("could not open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		record, err := r.ReadBytes('\n')
+		if err != nil {
+			// Either the end of the segment or a record that was cut short when the previous process stopped.
+			break
+		}
+
+		s.mu.Lock()
+		seg.appended++
+		s.mu.Unlock()
+
+		handle(record[:len(record)-1], seg.id)
+	}
+
+	s.mu.Lock()
+	seg.sealed = true
+	s.removeIfDoneLocked(seg)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Append writes a record to the current segment and returns the id of that segment.
+// The record must not contain newlines.
+func (s *Spool) Append(record []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != nil && s.current.size >= s.segmentSizeBytes {
+		if err := s.sealCurrentLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	if s.current == nil {
+		id := s.nextID
+		s.nextID++
+		path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, spoolSegmentSuffix))
+		// #nosec G304
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return 0, fmt.Errorf("could not create spool segment: %w", err)
+		}
+		s.current = &spoolSegment{id: id, path: path, file: f}
+		s.segments[id] = s.current
+	}
+
+	buf := make([]byte, 0, len(record)+1)
+	buf = append(buf, record...)
+	buf = append(buf, '\n')
+
+	n, err := s.current.file.Write(buf)
+	s.current.size += int64(n)
+	if err != nil {
+		return 0, fmt.Errorf("could not write to spool segment: %w", err)
+	}
+	s.current.appended++
+
+	if err := s.syncLocked(); err != nil {
+		return 0, err
+	}
+
+	return s.current.id, nil
+}
+
+// syncLocked syncs the current segment now if SyncInterval is zero, and otherwise makes sure a sync is scheduled.
+// An error from a scheduled sync is returned by the next call.
+func (s *Spool) syncLocked() error {
+	if err := s.syncErr; err != nil {
+		s.syncErr = nil
+		return fmt.Errorf("could not sync spool segment: %w", err)
+	}
+
+	if s.SyncInterval <= 0 {
+		if err := s.current.file.Sync(); err != nil {
+			return fmt.Errorf("could not sync spool segment: %w", err)
+		}
+		return nil
+	}
+
+	if s.syncTimer == nil {
+		seg := s.current
+		s.syncTimer = time.AfterFunc(s.SyncInterval, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.syncTimer = nil
+			if seg.file != nil {
+				s.syncErr = seg.file.Sync()
+			}
+		})
+	}
+	return nil
+}
+
+// Ack marks count records from the given segment as delivered.
+func (s *Spool) Ack(segment uint64, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seg, ok := s.segments[segment]
+	if !ok {
+		return
+	}
+
+	seg.acked += count
+	s.removeIfDoneLocked(seg)
+}
+
+// Close closes the current segment. The segment is removed if every record in it has been acknowledged.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		return nil
+	}
+
+	return s.sealCurrentLocked()
+}
+
+func (s *Spool) sealCurrentLocked() error {
+	seg := s.current
+	s.current = nil
+
+	if s.syncTimer != nil {
+		s.syncTimer.Stop()
+		s.syncTimer = nil
+	}
+
+	err := seg.file.Sync()
+	if closeErr := seg.file.Close(); err == nil {
+		err = closeErr
+	}
+	seg.file = nil
+	seg.sealed = true
+	s.removeIfDoneLocked(seg)
+
+	if err != nil {
+		return fmt.Errorf("could not close spool segment: %w", err)
+	}
+	return nil
+}
+
+func (s *Spool) removeIfDoneLocked(seg *spoolSegment) {
+	if !seg.sealed || seg.acked < seg.appended {
+		return
+	}
+
+	delete(s.segments, seg.id)
+	_ = os.Remove(seg.path)
+}
@@ -0,0 +1,82 @@
+package shipper
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSpoolReplaysUnacknowledgedRecords(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := OpenSpool(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var segments []uint64
+	for _, r := range []string{"first record", "second record", "third record"} {
+		segment, err := spool.Append([]byte(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments = append(segments, segment)
+	}
+	spool.Ack(segments[0], 1)
+	if err := spool.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spool, err = OpenSpool(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var replayed []string
+	err = spool.Replay(func(record []byte, segment uint64) {
+		replayed = append(replayed, string(record))
+		spool.Ack(segment, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replayed) != 2 || replayed[0] != "second record" || replayed[1] != "third record" {
+		t.Errorf("expected the two unacknowledged records to be replayed, got %q", replayed)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected all segments to be removed after acknowledging, found %d files", len(entries))
+	}
+}
+
+func TestSpoolSyncsAppendedRecords(t *testing.T) {
+	for _, interval := range []time.Duration{0, time.Millisecond} {
+		spool, err := OpenSpool(t.TempDir(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spool.SyncInterval = interval
+
+		for i := 0; i < 3; i++ {
+			if _, err := spool.Append([]byte("record")); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(2 * interval)
+		}
+
+		spool.mu.Lock()
+		if err := spool.syncErr; err != nil {
+			t.Errorf("sync with interval %s failed: %v", interval, err)
+		}
+		spool.mu.Unlock()
+
+		if err := spool.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
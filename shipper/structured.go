@@ -0,0 +1,112 @@
+package shipper
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event is a structured event. It is sent as is with IngestFormatStructured;
+// with IngestFormatUnstructured only the raw string is sent, or the attributes
+// as JSON if there is no raw string.
+type Event struct {
+	// Timestamp of the event. If zero, Humio uses the time of ingestion.
+	Timestamp  time.Time              `json:"timestamp"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	RawString  string                 `json:"rawstring,omitempty"`
+	// Tags select the datasource of the event. Keep the number of distinct values low.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+type EventHandler interface {
+	HandleEvent(event Event)
+}
+
+func (e *Event) size() int {
+	size := len(e.RawString)
+	for k, v := range e.Attributes {
+		size += len(k) + len(fmt.Sprint(v))
+	}
+	return size
+}
+
+// message returns the line to send for the event in the unstructured format.
+func (e event) message() string {
+	if e.structured == nil {
+		return e.line
+	}
+	if e.structured.RawString != "" || len(e.structured.Attributes) == 0 {
+		return e.structured.RawString
+	}
+	data, err := json.Marshal(e.structured.Attributes)
+	if err != nil {
+		return fmt.Sprint(e.structured.Attributes)
+	}
+	return string(data)
+}
+
+type structuredEventList struct {
+	Tags   map[string]string `json:"tags,omitempty"`
+	Events []structuredEvent `json:"events"`
+}
+
+type structuredEvent struct {
+	Timestamp  string                 `json:"timestamp,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	RawString  string                 `json:"rawstring,omitempty"`
+}
+
+// structuredEventLists groups consecutive events with the same tags into one list each.
+// Plain lines become events with only a raw string, and fields are added as attributes.
+func (s *LogShipper) structuredEventLists(batch []event) []structuredEventList {
+	var lists []structuredEventList
+	var listTags map[string]string
+
+	for i, e := range batch {
+		var se structuredEvent
+		var tags map[string]string
+
+		if e.structured != nil {
+			if !e.structured.Timestamp.IsZero() {
+				se.Timestamp = e.structured.Timestamp.Format(time.RFC3339Nano)
+			}
+			se.RawString = e.structured.RawString
+			tags = e.structured.Tags
+		} else {
+			se.RawString = e.line
+		}
+		se.Attributes = s.attributes(e)
+
+		if i == 0 || !equalFields(tags, listTags) {
+			listTags = tags
+			lists = append(lists, structuredEventList{Tags: tags})
+		}
+		l := &lists[len(lists)-1]
+		l.Events = append(l.Events, se)
+	}
+
+	return lists
+}
+
+// attributes merges the shipper fields, the event fields and the event attributes, in increasing order of precedence.
+func (s *LogShipper) attributes(e event) map[string]interface{} {
+	var own map[string]interface{}
+	if e.structured != nil {
+		own = e.structured.Attributes
+	}
+	if len(s.Fields) == 0 && len(e.fields) == 0 {
+		return own
+	}
+
+	attributes := make(map[string]interface{}, len(s.Fields)+len(e.fields)+len(own))
+	for k, v := range s.Fields {
+		attributes[k] = v
+	}
+	for k, v := range e.fields {
+		attributes[k] = v
+	}
+	for k, v := range own {
+		attributes[k] = v
+	}
+	return attributes
+}
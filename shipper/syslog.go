@@ -0,0 +1,238 @@
+package shipper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// SyslogMessage is the header of an RFC 3164 or RFC 5424 syslog message.
+// Fields that are missing from the message are left empty.
+type SyslogMessage struct {
+	Priority  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	// Raw is the full message, including the header.
+	Raw string
+}
+
+func (m SyslogMessage) Facility() string {
+	if f := m.Priority / 8; f < len(syslogFacilities) {
+		return syslogFacilities[f]
+	}
+	return strconv.Itoa(m.Priority / 8)
+}
+
+func (m SyslogMessage) Severity() string {
+	return syslogSeverities[m.Priority%8]
+}
+
+// ParseSyslogMessage parses the header of a syslog message. Messages without
+// a valid priority are rejected. Otherwise the header is parsed as far as
+// it follows RFC 5424 or RFC 3164, as many senders only do so loosely.
+func ParseSyslogMessage(raw string) (SyslogMessage, error) {
+	msg := SyslogMessage{Raw: raw}
+
+	end := -1
+	if len(raw) > 2 && raw[0] == '<' {
+		end = strings.IndexByte(raw[:min(len(raw), 5)], '>')
+	}
+	if end < 2 {
+		return msg, fmt.Errorf("syslog message does not start with a priority")
+	}
+	priority, err := strconv.Atoi(raw[1:end])
+	if err != nil || priority < 0 || priority > 191 {
+		return msg, fmt.Errorf("invalid syslog priority %q", raw[1:end])
+	}
+	msg.Priority = priority
+	rest := raw[end+1:]
+
+	if len(rest) > 2 && rest[0] == '1' && rest[1] == ' ' {
+		parseRFC5424Header(&msg, rest[2:])
+	} else {
+		parseRFC3164Header(&msg, rest)
+	}
+
+	return msg, nil
+}
+
+// parseRFC5424Header parses "TIMESTAMP HOSTNAME APP-NAME ...", where "-" marks a missing value.
+func parseRFC5424Header(msg *SyslogMessage, header string) {
+	var timestamp string
+	timestamp, header = nextSyslogToken(header)
+	msg.Hostname, header = nextSyslogToken(header)
+	msg.AppName, _ = nextSyslogToken(header)
+
+	if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+		msg.Timestamp = t
+	}
+}
+
+// parseRFC3164Header parses "Mmm dd hh:mm:ss HOSTNAME TAG: ...". The year is
+// missing from the timestamp, so the most recent matching date is used.
+func parseRFC3164Header(msg *SyslogMessage, header string) {
+	if len(header) < len(time.Stamp)+1 || header[len(time.Stamp)] != ' ' {
+		return
+	}
+	t, err := time.ParseInLocation(time.Stamp, header[:len(time.Stamp)], time.Local)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.AddDate(0, 1, 0)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	msg.Timestamp = t
+
+	var hostname, tag string
+	hostname, header = nextSyslogToken(header[len(time.Stamp)+1:])
+	tag, _ = nextSyslogToken(header)
+
+	// Some senders leave out the hostname, in which case the tag follows the timestamp.
+	if isSyslogTag(hostname) {
+		msg.AppName = syslogTagName(hostname)
+		return
+	}
+	msg.Hostname = hostname
+	if isSyslogTag(tag) {
+		msg.AppName = syslogTagName(tag)
+	}
+}
+
+func isSyslogTag(token string) bool {
+	return len(token) > 1 && token[len(token)-1] == ':'
+}
+
+// syslogTagName strips the trailing colon and process ID from a tag like "sshd[42]:".
+func syslogTagName(tag string) string {
+	tag = tag[:len(tag)-1]
+	if i := strings.IndexByte(tag, '['); i > 0 {
+		tag = tag[:i]
+	}
+	return tag
+}
+
+func nextSyslogToken(s string) (token, rest string) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		token, rest = s, ""
+	} else {
+		token, rest = s[:i], s[i+1:]
+	}
+	if token == "-" {
+		token = ""
+	}
+	return token, rest
+}
+
+// ReadSyslogFrames writes each message in a syslog stream, as sent over TCP,
+// to lines and ends it with EndLine. Each message is framed either by octet
+// counting, where it is preceded by its length and a space, or by a trailing
+// newline, as described in RFC 6587. Messages are written in parts, so those
+// longer than the max size of lines are handled by its oversize policy without
+// being read into memory in full. begin is called with the first part of each
+// message, which holds at least its header, before the message is written.
+func ReadSyslogFrames(r io.Reader, lines *LineBuffer, begin func(head []byte)) error {
+	reader := bufio.NewReader(r)
+	for {
+		first, err := reader.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		frame := &syslogFrame{lines: lines, begin: begin}
+		if first[0] >= '1' && first[0] <= '9' {
+			err = readOctetCountedFrame(reader, frame)
+		} else {
+			err = readNewlineFrame(reader, frame)
+		}
+		frame.end()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// syslogFrame writes the parts of a message to a LineBuffer, skipping empty messages.
+type syslogFrame struct {
+	lines   *LineBuffer
+	begin   func(head []byte)
+	started bool
+}
+
+func (f *syslogFrame) write(part []byte, last bool) {
+	if last {
+		part = bytes.TrimRight(part, "\r\n")
+	}
+	if !f.started {
+		if len(part) == 0 {
+			return
+		}
+		f.started = true
+		f.begin(part)
+	}
+	f.lines.Write(part)
+}
+
+func (f *syslogFrame) end() {
+	if f.started {
+		f.lines.EndLine()
+	}
+}
+
+func readOctetCountedFrame(reader *bufio.Reader, frame *syslogFrame) error {
+	length, err := reader.ReadString(' ')
+	if err != nil {
+		return err
+	}
+	size, err := strconv.Atoi(length[:len(length)-1])
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid syslog frame length %q", length)
+	}
+
+	for size > 0 {
+		part, err := reader.Peek(min(size, reader.Size()))
+		size -= len(part)
+		frame.write(part, size == 0)
+		if _, discardErr := reader.Discard(len(part)); discardErr != nil {
+			return discardErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readNewlineFrame(reader *bufio.Reader, frame *syslogFrame) error {
+	for {
+		part, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			frame.write(part, false)
+			continue
+		}
+		frame.write(part, true)
+		return err
+	}
+}
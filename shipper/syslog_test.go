@@ -0,0 +1,83 @@
+package shipper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseSyslogMessage(t *testing.T) {
+	tests := []struct {
+		raw, facility, severity, hostname, appName string
+	}{
+		{"<34>Oct 11 22:14:15 mymachine su[12]: 'su root' failed", "auth", "crit", "mymachine", "su"},
+		{"<13>Oct  1 22:14:15 sshd: no hostname", "user", "notice", "", "sshd"},
+		{"<165>1 2003-10-11T22:14:15.003Z host2 evntslog - ID47 - An app event", "local4", "notice", "host2", "evntslog"},
+		{"<0>1 - - - - - -", "kern", "emerg", "", ""},
+	}
+
+	for _, test := range tests {
+		msg, err := ParseSyslogMessage(test.raw)
+		if err != nil {
+			t.Errorf("%q: %v", test.raw, err)
+			continue
+		}
+		if msg.Facility() != test.facility || msg.Severity() != test.severity || msg.Hostname != test.hostname || msg.AppName != test.appName {
+			t.Errorf("%q: got %s.%s host=%q app=%q", test.raw, msg.Facility(), msg.Severity(), msg.Hostname, msg.AppName)
+		}
+	}
+
+	if _, err := ParseSyslogMessage("no priority"); err == nil {
+		t.Error("expected an error for a message without a priority")
+	}
+}
+
+func TestReadSyslogFrames(t *testing.T) {
+	input := "10 <13>first\n<13>second\n9 <13>third<13>fourth"
+
+	var frames, heads []string
+	lines := &LineBuffer{MaxSize: 1024, Handle: func(line string, fields map[string]string) {
+		frames = append(frames, line)
+	}}
+	err := ReadSyslogFrames(strings.NewReader(input), lines, func(head []byte) {
+		heads = append(heads, string(head))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"<13>first", "<13>second", "<13>third", "<13>fourth"}
+	if strings.Join(frames, "|") != strings.Join(expected, "|") {
+		t.Errorf("got %q, expected %q", frames, expected)
+	}
+	if strings.Join(heads, "|") != strings.Join(expected, "|") {
+		t.Errorf("got heads %q, expected %q", heads, expected)
+	}
+}
+
+func TestReadSyslogFramesAppliesOversizePolicy(t *testing.T) {
+	long := "<13>" + strings.Repeat("x", 10000)
+	input := fmt.Sprintf("%d %s%s\n<13>short\n", len(long), long, long)
+
+	var frames []string
+	var marks []map[string]string
+	lines := &LineBuffer{MaxSize: 100, Policy: OversizeTruncate, Handle: func(line string, fields map[string]string) {
+		frames = append(frames, line)
+		marks = append(marks, fields)
+	}}
+	err := ReadSyslogFrames(strings.NewReader(input), lines, func(head []byte) {
+		if !strings.HasPrefix(string(head), "<13>") {
+			t.Errorf("expected each head to start with the header, got %.20q", head)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) != 3 || frames[0] != long[:100] || frames[1] != long[:100] || frames[2] != "<13>short" {
+		t.Fatalf("expected two truncated messages and a short one, got %d messages", len(frames))
+	}
+	if marks[0]["@truncated"] != "true" || marks[1]["@truncated"] != "true" || marks[2] != nil {
+		t.Errorf("expected the long messages to be marked as truncated, got %v", marks)
+	}
+}